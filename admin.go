@@ -0,0 +1,197 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/29
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// 管理接口的默认挂载路径
+const AdminPrefix = "/debug/zdb"
+
+// 管理接口ping db的超时时间, 列出所有db时并行ping, 共用这个超时
+const AdminPingTimeout = time.Second
+
+// 管理接口的鉴权函数, 返回false会拒绝请求
+type AdminAuthFunc func(r *http.Request) bool
+
+// 管理接口中的db状态
+type adminDBStatus struct {
+    *DBStatus
+    Healthy     bool               `json:"healthy"`
+    PingLatency string             `json:"ping_latency,omitempty"`
+    PingError   string             `json:"ping_error,omitempty"`
+    Pool        map[string]float64 `json:"pool,omitempty"`
+}
+
+type adminHandler struct {
+    factory *DBFactory
+    auth    AdminAuthFunc
+}
+
+// 创建管理接口, 挂载方式为 http.Handle(AdminPrefix+"/", handler)
+//
+// 只读接口:
+//  GET  /dbs            所有db的状态, 健康检查结果和连接池状态
+//  GET  /config         所有db的配置, 敏感字段已隐藏
+//  POST /ping?dbname=   ping一个db
+// 修改接口, 需要auth返回true, auth为nil时总是拒绝:
+//  POST /reconnect?dbname=  重连一个db
+//  POST /remove?dbname=     移除一个db
+//  POST /connect            连接所有未连接的db
+// GET接口可以用 format=toml 参数输出toml
+func (m *DBFactory) AdminHandler(auth AdminAuthFunc) http.Handler {
+    return &adminHandler{factory: m, auth: auth}
+}
+
+func (m *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, AdminPrefix), "/")
+    dbname := r.URL.Query().Get("dbname")
+
+    switch path {
+    case "/dbs":
+        if m.checkMethod(w, r, http.MethodGet) {
+            m.writeDump(w, r, m.listDBs(r.Context()))
+        }
+    case "/config":
+        if m.checkMethod(w, r, http.MethodGet) {
+            m.writeDump(w, r, m.factory.DumpConfig())
+        }
+    case "/ping":
+        if m.checkMethod(w, r, http.MethodPost) && m.checkDBName(w, dbname) {
+            ctx, cancel := context.WithTimeout(r.Context(), AdminPingTimeout)
+            defer cancel()
+            latency, err := m.factory.Ping(ctx, dbname)
+            if err != nil {
+                m.writeError(w, http.StatusServiceUnavailable, err.Error())
+                return
+            }
+            m.writeJSON(w, http.StatusOK, map[string]interface{}{"dbname": dbname, "latency": latency.String()})
+        }
+    case "/reconnect":
+        if m.checkMethod(w, r, http.MethodPost) && m.checkAuth(w, r) && m.checkDBName(w, dbname) {
+            m.factory.log.Warn("通过管理接口重连db", F("dbname", dbname), F("remote", r.RemoteAddr))
+            m.writeResult(w, m.factory.Reconnect(dbname))
+        }
+    case "/remove":
+        if m.checkMethod(w, r, http.MethodPost) && m.checkAuth(w, r) && m.checkDBName(w, dbname) {
+            m.factory.log.Warn("通过管理接口移除db", F("dbname", dbname), F("remote", r.RemoteAddr))
+            m.factory.RemoveDB(dbname)
+            m.writeResult(w, nil)
+        }
+    case "/connect":
+        if m.checkMethod(w, r, http.MethodPost) && m.checkAuth(w, r) {
+            m.factory.log.Warn("通过管理接口连接所有db", F("remote", r.RemoteAddr))
+            m.writeResult(w, m.factory.ConnectAllDBContext(r.Context()))
+        }
+    default:
+        m.writeError(w, http.StatusNotFound, "未知的接口")
+    }
+}
+
+// 获取所有db的状态, 并行ping已连接的db, 总耗时不超过AdminPingTimeout
+func (m *adminHandler) listDBs(ctx context.Context) []*adminDBStatus {
+    ctx, cancel := context.WithTimeout(ctx, AdminPingTimeout)
+    defer cancel()
+
+    dbs := m.factory.ListDBs()
+    out := make([]*adminDBStatus, len(dbs))
+    var wg sync.WaitGroup
+    for i, status := range dbs {
+        out[i] = &adminDBStatus{DBStatus: status}
+        instance := m.factory.GetDBInstance(status.DBName)
+        if instance == nil {
+            continue
+        }
+
+        wg.Add(1)
+        go func(s *adminDBStatus, instance *DBInstance) {
+            defer wg.Done()
+            s.Pool = poolStats(instance.instance)
+
+            latency, err := m.factory.Ping(ctx, s.DBName)
+            s.PingLatency = latency.String()
+            if err != nil {
+                s.PingError = err.Error()
+                return
+            }
+            s.Healthy = true
+        }(out[i], instance)
+    }
+    wg.Wait()
+    return out
+}
+
+func (m *adminHandler) checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+    if r.Method != method {
+        w.Header().Set("Allow", method)
+        m.writeError(w, http.StatusMethodNotAllowed, "只支持"+method)
+        return false
+    }
+    return true
+}
+
+func (m *adminHandler) checkAuth(w http.ResponseWriter, r *http.Request) bool {
+    if m.auth == nil || !m.auth(r) {
+        m.writeError(w, http.StatusForbidden, "没有权限")
+        return false
+    }
+    return true
+}
+
+func (m *adminHandler) checkDBName(w http.ResponseWriter, dbname string) bool {
+    if dbname == "" {
+        m.writeError(w, http.StatusBadRequest, "dbname为空")
+        return false
+    }
+    return true
+}
+
+func (m *adminHandler) writeDump(w http.ResponseWriter, r *http.Request, v interface{}) {
+    format := DumpFormat(r.URL.Query().Get("format"))
+    if format == "" {
+        format = JSONFormat
+    }
+
+    bs, err := MarshalDump(v, format)
+    if err != nil {
+        m.writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    if format == TOMLFormat {
+        w.Header().Set("Content-Type", "application/toml; charset=utf-8")
+    } else {
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    }
+    _, _ = w.Write(bs)
+}
+
+func (m *adminHandler) writeResult(w http.ResponseWriter, err error) {
+    if err != nil {
+        m.writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    m.writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true})
+}
+
+func (m *adminHandler) writeError(w http.ResponseWriter, code int, msg string) {
+    m.writeJSON(w, code, map[string]interface{}{"error": msg})
+}
+
+func (m *adminHandler) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(code)
+    _ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,369 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/15
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Shopify/sarama"
+    "github.com/go-redis/redis"
+    "github.com/jinzhu/gorm"
+    "github.com/zlyuancn/zerrors"
+)
+
+// 分片中的熔断器配置, 它是一个表, 字段见CircuitBreakerConfig, 存在时启用熔断器
+//
+// 支持redis, mysql, es和kafka同步生产者
+const CircuitBreakerField = "circuit_breaker"
+
+// 熔断器配置的默认值
+const (
+    DefaultBreakerFailureRatio = 0.5
+    DefaultBreakerWindow       = time.Second * 10
+    DefaultBreakerMinRequests  = 10
+    DefaultBreakerOpenDuration = time.Second * 30
+)
+
+// 支持在实例上装配熔断器和限流的db类型
+var wrappableDBTypes = map[DBType]bool{
+    Redis:         true,
+    Mysql:         true,
+    ESv6:          true,
+    ESv7:          true,
+    KafkaProducer: true,
+}
+
+// 熔断器配置
+type CircuitBreakerConfig struct {
+    FailureRatio float64  // 窗口内失败的比例达到该值时打开熔断器, 默认为0.5
+    Window       Duration // 统计窗口(毫秒), 默认为10秒
+    MinRequests  int      // 窗口内的请求数达到该值后才会判断失败比例, 默认为10
+    OpenDuration Duration // 打开后多久进入半开状态(毫秒), 默认为30秒, 半开时只放行一个请求, 成功后关闭, 失败后重新打开
+}
+
+// 熔断器状态
+type CircuitState int
+
+const (
+    CircuitClosed CircuitState = iota
+    CircuitOpen
+    CircuitHalfOpen
+)
+
+var circuitStateNames = map[CircuitState]string{
+    CircuitClosed:   "closed",
+    CircuitOpen:     "open",
+    CircuitHalfOpen: "half_open",
+}
+
+func (s CircuitState) String() string {
+    if name, ok := circuitStateNames[s]; ok {
+        return name
+    }
+    return "unknown"
+}
+
+// 熔断器打开时操作返回的错误
+type CircuitOpenError struct {
+    DBName string
+}
+
+func (e *CircuitOpenError) Error() string {
+    return fmt.Sprintf("<%s>的熔断器已打开", e.DBName)
+}
+
+// 判断错误是否为熔断器打开
+func IsCircuitOpen(err error) bool {
+    _, ok := zerrors.Cause(err).(*CircuitOpenError)
+    return ok
+}
+
+// 熔断器状态, 用于ListDBs
+type CircuitBreakerStatus struct {
+    State    string    `json:"state"`
+    Requests int       `json:"requests"`  // 当前窗口内的请求数
+    Failures int       `json:"failures"`  // 当前窗口内的失败数
+    OpenedAt time.Time `json:"opened_at"` // 最后一次打开的时间, 没有打开过时为零值
+}
+
+// 熔断器, 同一个db的所有实例共用一个熔断器, 重连后状态保持不变
+type circuitBreaker struct {
+    dbname       string
+    failureRatio float64
+    window       time.Duration
+    minRequests  int
+    openDuration time.Duration
+    log          ILogger
+
+    state       CircuitState
+    windowStart time.Time
+    requests    int
+    failures    int
+    openedAt    time.Time
+    probing     bool // 半开时是否已放行了一个请求
+    mx          sync.Mutex
+}
+
+// 解析分片的circuit_breaker, 不存在时返回nil
+func (m *DBFactory) parseCircuitBreaker(dbname string, dbtype DBType, shard map[string]interface{}) (*circuitBreaker, error) {
+    v, ok := shardField(shard, CircuitBreakerField)
+    if !ok {
+        return nil, nil
+    }
+    if !wrappableDBTypes[dbtype] {
+        return nil, zerrors.NewSimplef("<%s>的db类型<%s>不支持%s", dbname, dbtype, CircuitBreakerField)
+    }
+    table, ok := v.(map[string]interface{})
+    if !ok {
+        return nil, zerrors.NewSimplef("<%s>的%s必须为表", dbname, CircuitBreakerField)
+    }
+
+    conf := new(CircuitBreakerConfig)
+    if err := decodeShard(table, conf); err != nil {
+        return nil, zerrors.WrapSimplef(err, "<%s>的%s解析失败", dbname, CircuitBreakerField)
+    }
+    return newCircuitBreaker(dbname, conf, m.log), nil
+}
+
+func newCircuitBreaker(dbname string, conf *CircuitBreakerConfig, log ILogger) *circuitBreaker {
+    b := &circuitBreaker{
+        dbname:       dbname,
+        failureRatio: conf.FailureRatio,
+        window:       conf.Window.Duration(),
+        minRequests:  conf.MinRequests,
+        openDuration: conf.OpenDuration.Duration(),
+        log:          log,
+    }
+    if b.failureRatio <= 0 {
+        b.failureRatio = DefaultBreakerFailureRatio
+    }
+    if b.window <= 0 {
+        b.window = DefaultBreakerWindow
+    }
+    if b.minRequests <= 0 {
+        b.minRequests = DefaultBreakerMinRequests
+    }
+    if b.openDuration <= 0 {
+        b.openDuration = DefaultBreakerOpenDuration
+    }
+    return b
+}
+
+// 判断是否放行一个请求, 放行后必须调用report报告结果
+func (b *circuitBreaker) allow() error {
+    b.mx.Lock()
+    defer b.mx.Unlock()
+
+    switch b.state {
+    case CircuitOpen:
+        if time.Since(b.openedAt) < b.openDuration {
+            return &CircuitOpenError{DBName: b.dbname}
+        }
+        b.state, b.probing = CircuitHalfOpen, false
+        b.log.Info("熔断器进入半开状态", F("dbname", b.dbname))
+        fallthrough
+    case CircuitHalfOpen:
+        if b.probing {
+            return &CircuitOpenError{DBName: b.dbname}
+        }
+        b.probing = true
+    }
+    return nil
+}
+
+// 报告一个已放行请求的结果, failed表示请求失败
+func (b *circuitBreaker) report(failed bool) {
+    b.mx.Lock()
+    defer b.mx.Unlock()
+
+    now := time.Now()
+    switch b.state {
+    case CircuitHalfOpen:
+        if failed {
+            b.open(now)
+            return
+        }
+        b.state, b.probing = CircuitClosed, false
+        b.windowStart, b.requests, b.failures = now, 0, 0
+        b.log.Info("熔断器已关闭", F("dbname", b.dbname))
+        return
+    case CircuitOpen:
+        return
+    }
+
+    if now.Sub(b.windowStart) >= b.window {
+        b.windowStart, b.requests, b.failures = now, 0, 0
+    }
+    b.requests++
+    if failed {
+        b.failures++
+    }
+    if b.requests >= b.minRequests && float64(b.failures) >= float64(b.requests)*b.failureRatio {
+        b.open(now)
+    }
+}
+
+// 打开熔断器, 调用者需要持有锁
+func (b *circuitBreaker) open(now time.Time) {
+    b.log.Warn("熔断器已打开", F("dbname", b.dbname), F("requests", b.requests), F("failures", b.failures))
+    b.state, b.openedAt, b.probing = CircuitOpen, now, false
+    b.requests, b.failures = 0, 0
+}
+
+func (b *circuitBreaker) status() *CircuitBreakerStatus {
+    b.mx.Lock()
+    defer b.mx.Unlock()
+
+    state := b.state
+    if state == CircuitOpen && time.Since(b.openedAt) >= b.openDuration {
+        state = CircuitHalfOpen
+    }
+    return &CircuitBreakerStatus{
+        State:    state.String(),
+        Requests: b.requests,
+        Failures: b.failures,
+        OpenedAt: b.openedAt,
+    }
+}
+
+// 熔断器插件, 每个db的实例有自己的插件
+type breakerPlugin struct {
+    breaker *circuitBreaker
+}
+
+var (
+    _ redisLimiterPlugin = (*breakerPlugin)(nil)
+    _ gormPlugin         = (*breakerPlugin)(nil)
+    _ httpPlugin         = (*breakerPlugin)(nil)
+    _ kafkaPlugin        = (*breakerPlugin)(nil)
+)
+
+func (m *breakerPlugin) redisLimiter(info *connectInfo) redis.Limiter {
+    return redisBreakerLimiter{m.breaker}
+}
+
+// 将熔断器适配为redis.Limiter, redis.Nil和redis返回的错误(如WRONGTYPE)不算失败
+type redisBreakerLimiter struct {
+    breaker *circuitBreaker
+}
+
+func (m redisBreakerLimiter) Allow() error {
+    return m.breaker.allow()
+}
+
+func (m redisBreakerLimiter) ReportResult(err error) {
+    m.breaker.report(err != nil && !isRedisReplyError(err))
+}
+
+// redis返回的错误, 新版本的go-redis通过RedisError方法标识
+type redisReplyError interface {
+    RedisError()
+}
+
+// 判断是否为redis返回的错误
+//
+// go-redis v6中redis返回的错误是包内部的proto.RedisError, 没有RedisError方法, 它与redis.Nil是同一类型
+func isRedisReplyError(err error) bool {
+    if err == redis.Nil {
+        return true
+    }
+    if _, ok := err.(redisReplyError); ok {
+        return true
+    }
+    reply := redis.Nil
+    return errors.As(err, &reply)
+}
+
+const gormBreakerKey = "zdb:breaker_allowed"
+
+func (m *breakerPlugin) registerGorm(info *connectInfo, db *gorm.DB) {
+    before := func(scope *gorm.Scope) {
+        if err := m.breaker.allow(); err != nil {
+            scope.Err(err)
+            return
+        }
+        scope.InstanceSet(gormBreakerKey, true)
+    }
+    after := func(scope *gorm.Scope) {
+        if _, ok := scope.InstanceGet(gormBreakerKey); !ok {
+            return
+        }
+        m.breaker.report(scope.HasError() && !gorm.IsRecordNotFoundError(scope.DB().Error))
+    }
+
+    cb := db.Callback()
+    cb.Create().Before("gorm:create").Register("zdb:breaker_before_create", before)
+    cb.Create().After("gorm:create").Register("zdb:breaker_after_create", after)
+    cb.Update().Before("gorm:update").Register("zdb:breaker_before_update", before)
+    cb.Update().After("gorm:update").Register("zdb:breaker_after_update", after)
+    cb.Delete().Before("gorm:delete").Register("zdb:breaker_before_delete", before)
+    cb.Delete().After("gorm:delete").Register("zdb:breaker_after_delete", after)
+    cb.Query().Before("gorm:query").Register("zdb:breaker_before_query", before)
+    cb.Query().After("gorm:query").Register("zdb:breaker_after_query", after)
+    cb.RowQuery().Before("gorm:row_query").Register("zdb:breaker_before_row_query", before)
+    cb.RowQuery().After("gorm:row_query").Register("zdb:breaker_after_row_query", after)
+}
+
+func (m *breakerPlugin) wrapTransport(info *connectInfo, rt http.RoundTripper) http.RoundTripper {
+    return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+        if isESInternalRequest(req) {
+            return rt.RoundTrip(req)
+        }
+        if err := m.breaker.allow(); err != nil {
+            return nil, err
+        }
+        resp, err := rt.RoundTrip(req)
+        m.breaker.report(err != nil || resp.StatusCode >= 500)
+        return resp, err
+    })
+}
+
+// es客户端自身发出的请求(节点嗅探和健康检查), 不经过熔断器, 否则熔断器打开后客户端会认为节点全部不可用
+func isESInternalRequest(req *http.Request) bool {
+    path := req.URL.Path
+    return (req.Method == http.MethodHead && (path == "" || path == "/")) || strings.HasPrefix(path, "/_nodes")
+}
+
+func (m *breakerPlugin) wrapSyncProducer(info *connectInfo, p sarama.SyncProducer) sarama.SyncProducer {
+    return &breakerSyncProducer{SyncProducer: p, breaker: m.breaker}
+}
+
+// 异步生产者的结果在Errors中返回, 无法在发送时判断, 所以不使用熔断器
+func (m *breakerPlugin) wrapAsyncProducer(info *connectInfo, p sarama.AsyncProducer) sarama.AsyncProducer {
+    return p
+}
+
+// 带熔断器的同步生产者
+type breakerSyncProducer struct {
+    sarama.SyncProducer
+    breaker *circuitBreaker
+}
+
+func (m *breakerSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+    if err = m.breaker.allow(); err != nil {
+        return
+    }
+    partition, offset, err = m.SyncProducer.SendMessage(msg)
+    m.breaker.report(err != nil)
+    return
+}
+
+func (m *breakerSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+    if err := m.breaker.allow(); err != nil {
+        return err
+    }
+    err := m.SyncProducer.SendMessages(msgs)
+    m.breaker.report(err != nil)
+    return err
+}
@@ -0,0 +1,214 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/15
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "errors"
+    "io"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/go-redis/redis"
+    "github.com/zlyuancn/zerrors"
+)
+
+// 熔断器状态机的操作
+const (
+    breakerAllow   = "allow"   // 调用allow
+    breakerSuccess = "success" // 报告成功
+    breakerFailure = "failure" // 报告失败
+    breakerReopen  = "reopen"  // 经过OpenDuration
+    breakerWindow  = "window"  // 经过Window
+)
+
+type breakerStep struct {
+    action    string
+    wantErr   bool // 只用于allow
+    wantState CircuitState
+}
+
+// 依次放行并报告失败n次
+func breakerFailures(n int, final CircuitState) []breakerStep {
+    steps := make([]breakerStep, 0, n*2)
+    for i := 0; i < n; i++ {
+        state := CircuitClosed
+        if i == n-1 {
+            state = final
+        }
+        steps = append(steps,
+            breakerStep{action: breakerAllow, wantState: CircuitClosed},
+            breakerStep{action: breakerFailure, wantState: state},
+        )
+    }
+    return steps
+}
+
+func concatSteps(parts ...[]breakerStep) []breakerStep {
+    var out []breakerStep
+    for _, p := range parts {
+        out = append(out, p...)
+    }
+    return out
+}
+
+func TestCircuitBreaker(t *testing.T) {
+    tests := []struct {
+        name  string
+        steps []breakerStep
+    }{
+        {
+            name:  "stays closed below min requests",
+            steps: breakerFailures(3, CircuitClosed),
+        },
+        {
+            name:  "opens when the failure ratio is reached",
+            steps: concatSteps(breakerFailures(4, CircuitOpen), []breakerStep{{action: breakerAllow, wantErr: true, wantState: CircuitOpen}}),
+        },
+        {
+            name: "stays closed below the failure ratio",
+            steps: []breakerStep{
+                {action: breakerSuccess, wantState: CircuitClosed},
+                {action: breakerSuccess, wantState: CircuitClosed},
+                {action: breakerSuccess, wantState: CircuitClosed},
+                {action: breakerFailure, wantState: CircuitClosed},
+                {action: breakerAllow, wantState: CircuitClosed},
+            },
+        },
+        {
+            name: "window resets the counters",
+            steps: concatSteps(breakerFailures(3, CircuitClosed), []breakerStep{
+                {action: breakerWindow, wantState: CircuitClosed},
+                {action: breakerFailure, wantState: CircuitClosed},
+                {action: breakerFailure, wantState: CircuitClosed},
+                {action: breakerFailure, wantState: CircuitClosed},
+                {action: breakerFailure, wantState: CircuitOpen},
+            }),
+        },
+        {
+            name: "half open probe success closes",
+            steps: concatSteps(breakerFailures(4, CircuitOpen), []breakerStep{
+                {action: breakerReopen, wantState: CircuitOpen},
+                {action: breakerAllow, wantState: CircuitHalfOpen},
+                {action: breakerAllow, wantErr: true, wantState: CircuitHalfOpen},
+                {action: breakerSuccess, wantState: CircuitClosed},
+                {action: breakerAllow, wantState: CircuitClosed},
+            }),
+        },
+        {
+            name: "half open probe failure reopens",
+            steps: concatSteps(breakerFailures(4, CircuitOpen), []breakerStep{
+                {action: breakerReopen, wantState: CircuitOpen},
+                {action: breakerAllow, wantState: CircuitHalfOpen},
+                {action: breakerFailure, wantState: CircuitOpen},
+                {action: breakerAllow, wantErr: true, wantState: CircuitOpen},
+            }),
+        },
+        {
+            name: "results reported while open are ignored",
+            steps: concatSteps(breakerFailures(4, CircuitOpen), []breakerStep{
+                {action: breakerSuccess, wantState: CircuitOpen},
+                {action: breakerSuccess, wantState: CircuitOpen},
+                {action: breakerAllow, wantErr: true, wantState: CircuitOpen},
+            }),
+        },
+    }
+
+    conf := &CircuitBreakerConfig{FailureRatio: 0.5, Window: 10000, MinRequests: 4, OpenDuration: 10000}
+    for _, tt := range tests {
+        b := newCircuitBreaker("db", conf, nopLogger{})
+        b.windowStart = time.Now()
+        for i, step := range tt.steps {
+            switch step.action {
+            case breakerAllow:
+                err := b.allow()
+                if (err != nil) != step.wantErr {
+                    t.Errorf("%s: step %d allow err = %v, wantErr %v", tt.name, i, err, step.wantErr)
+                }
+                if err != nil && !IsCircuitOpen(zerrors.WrapSimple(err, "wrapped")) {
+                    t.Errorf("%s: step %d IsCircuitOpen(%v) = false", tt.name, i, err)
+                }
+            case breakerSuccess:
+                b.report(false)
+            case breakerFailure:
+                b.report(true)
+            case breakerReopen:
+                b.openedAt = b.openedAt.Add(-b.openDuration)
+            case breakerWindow:
+                b.windowStart = b.windowStart.Add(-b.window)
+            }
+            if b.state != step.wantState {
+                t.Errorf("%s: step %d %s state = %s, want %s", tt.name, i, step.action, b.state, step.wantState)
+                break
+            }
+        }
+    }
+}
+
+func TestCircuitBreakerDefaults(t *testing.T) {
+    b := newCircuitBreaker("db", &CircuitBreakerConfig{}, nopLogger{})
+    tests := []struct {
+        name string
+        got  interface{}
+        want interface{}
+    }{
+        {name: "failure ratio", got: b.failureRatio, want: DefaultBreakerFailureRatio},
+        {name: "window", got: b.window, want: DefaultBreakerWindow},
+        {name: "min requests", got: b.minRequests, want: DefaultBreakerMinRequests},
+        {name: "open duration", got: b.openDuration, want: DefaultBreakerOpenDuration},
+    }
+    for _, tt := range tests {
+        if tt.got != tt.want {
+            t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+        }
+    }
+}
+
+func TestIsRedisReplyError(t *testing.T) {
+    tests := []struct {
+        name string
+        err  error
+        want bool
+    }{
+        {name: "nil reply", err: redis.Nil, want: true},
+        {name: "connection error", err: errors.New("dial tcp: connection refused"), want: false},
+        {name: "eof", err: io.EOF, want: false},
+        {name: "circuit open", err: &CircuitOpenError{DBName: "db"}, want: false},
+    }
+    for _, tt := range tests {
+        if got := isRedisReplyError(tt.err); got != tt.want {
+            t.Errorf("%s: isRedisReplyError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+        }
+    }
+}
+
+func TestIsESInternalRequest(t *testing.T) {
+    tests := []struct {
+        method string
+        url    string
+        want   bool
+    }{
+        {method: http.MethodHead, url: "http://es:9200/", want: true},
+        {method: http.MethodHead, url: "http://es:9200", want: true},
+        {method: http.MethodGet, url: "http://es:9200/_nodes/http", want: true},
+        {method: http.MethodGet, url: "http://es:9200/_nodes/_all/http", want: true},
+        {method: http.MethodGet, url: "http://es:9200/", want: false},
+        {method: http.MethodHead, url: "http://es:9200/index", want: false},
+        {method: http.MethodPost, url: "http://es:9200/index/_search", want: false},
+    }
+    for _, tt := range tests {
+        req, err := http.NewRequest(tt.method, tt.url, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := isESInternalRequest(req); got != tt.want {
+            t.Errorf("isESInternalRequest(%s %s) = %v, want %v", tt.method, tt.url, got, tt.want)
+        }
+    }
+}
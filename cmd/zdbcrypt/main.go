@@ -0,0 +1,129 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/27
+   Description :  配置值加解密工具
+-------------------------------------------------
+*/
+
+package main
+
+import (
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+
+    "golang.org/x/crypto/ssh/terminal"
+
+    "github.com/zlyuancn/zdbfactory"
+)
+
+const usage = `zdbcrypt 配置值加解密工具
+
+用法:
+  zdbcrypt genkey -key <密钥文件>
+  zdbcrypt encrypt -key <密钥文件> -keyid <密钥id>
+  zdbcrypt decrypt -key <密钥文件> <加密值>
+
+encrypt从标准输入读取明文, 标准输入为终端时会提示输入且不回显
+decrypt用密钥文件解密, 密钥id使用加密值中记录的id
+密钥文件的内容为hex编码的16, 24或32字节密钥
+`
+
+func main() {
+    if len(os.Args) < 2 {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    cmd := os.Args[1]
+    fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+    keyFile := fs.String("key", "", "密钥文件")
+    keyID := fs.String("keyid", "", "密钥id")
+    _ = fs.Parse(os.Args[2:])
+
+    if *keyFile == "" {
+        fail("必须指定-key")
+    }
+
+    switch cmd {
+    case "genkey":
+        key, err := zdbfactory.GenerateCipherKey()
+        if err != nil {
+            fail(err)
+        }
+        if err = ioutil.WriteFile(*keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+            fail(err)
+        }
+    case "encrypt":
+        if *keyID == "" || fs.NArg() != 0 {
+            fail(usage)
+        }
+        key, err := zdbfactory.LoadCipherKeyFile(*keyFile)
+        if err != nil {
+            fail(err)
+        }
+        plaintext, err := readPlaintext()
+        if err != nil {
+            fail(err)
+        }
+        out, err := zdbfactory.EncryptValue(*keyID, key, plaintext)
+        if err != nil {
+            fail(err)
+        }
+        fmt.Println(out)
+    case "decrypt":
+        if *keyID != "" || fs.NArg() != 1 {
+            fail(usage)
+        }
+        key, err := zdbfactory.LoadCipherKeyFile(*keyFile)
+        if err != nil {
+            fail(err)
+        }
+        value := fs.Arg(0)
+        out, err := zdbfactory.DecryptValue(map[string][]byte{valueKeyID(value): key}, value)
+        if err != nil {
+            fail(err)
+        }
+        fmt.Println(out)
+    default:
+        fail(usage)
+    }
+}
+
+// 读取明文, 标准输入为终端时提示输入且不回显, 否则读取全部输入并去掉末尾的换行
+func readPlaintext() (string, error) {
+    fd := int(os.Stdin.Fd())
+    if terminal.IsTerminal(fd) {
+        fmt.Fprint(os.Stderr, "明文: ")
+        b, err := terminal.ReadPassword(fd)
+        fmt.Fprintln(os.Stderr)
+        return string(b), err
+    }
+
+    b, err := ioutil.ReadAll(os.Stdin)
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), nil
+}
+
+// 获取加密值中记录的密钥id, 格式错误时返回空字符串, 由DecryptValue报告错误
+func valueKeyID(value string) string {
+    if !strings.HasPrefix(value, zdbfactory.EncryptedValuePrefix) {
+        return ""
+    }
+    value = value[len(zdbfactory.EncryptedValuePrefix):]
+    if i := strings.Index(value, ":"); i >= 0 {
+        return value[:i]
+    }
+    return ""
+}
+
+func fail(v interface{}) {
+    fmt.Fprintln(os.Stderr, v)
+    os.Exit(1)
+}
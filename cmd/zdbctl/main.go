@@ -0,0 +1,263 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/30
+   Description :  配置校验和连接探测工具
+-------------------------------------------------
+*/
+
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/zlyuancn/zdbfactory"
+)
+
+const usage = `zdbctl 配置校验和连接探测工具
+
+用法:
+  zdbctl validate [选项] <配置文件>            校验配置文件中的所有分片
+  zdbctl ping [选项] <配置文件> [dbname...]    连接并ping db, 不指定dbname时ping所有db
+  zdbctl list [选项] <配置文件>                列出解析后的分片, 敏感字段会被隐藏
+
+根据扩展名使用toml, json或yaml加载, 其它文件使用viper加载
+
+选项:
+`
+
+type options struct {
+    keyFile string
+    keyID   string
+    prefix  string
+    section string
+    profile string
+    timeout time.Duration
+    format  string
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        printUsage(nil)
+        os.Exit(2)
+    }
+
+    cmd := os.Args[1]
+    opts := new(options)
+    fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+    fs.StringVar(&opts.keyFile, "key", "", "解密加密值的密钥文件")
+    fs.StringVar(&opts.keyID, "keyid", "", "密钥文件对应的密钥id")
+    fs.StringVar(&opts.prefix, "prefix", zdbfactory.DBPrefix, "分片key的前缀")
+    fs.StringVar(&opts.section, "section", "", "分片所在的节点, 用.分隔")
+    fs.StringVar(&opts.profile, "profile", "", "profile名, 为空时使用环境变量"+zdbfactory.ProfileEnv)
+    fs.DurationVar(&opts.timeout, "timeout", time.Second*5, "ping时每个db的连接和ping超时")
+    fs.StringVar(&opts.format, "format", "toml", "list的输出格式, json或toml")
+    fs.Usage = func() { printUsage(fs) }
+    _ = fs.Parse(os.Args[2:])
+
+    if fs.NArg() < 1 {
+        fs.Usage()
+        os.Exit(2)
+    }
+    file, args := fs.Arg(0), fs.Args()[1:]
+
+    var ok bool
+    switch cmd {
+    case "validate":
+        ok = validate(opts, file)
+    case "ping":
+        ok = ping(opts, file, args)
+    case "list":
+        ok = list(opts, file)
+    default:
+        fs.Usage()
+        os.Exit(2)
+    }
+    if !ok {
+        os.Exit(1)
+    }
+}
+
+func printUsage(fs *flag.FlagSet) {
+    fmt.Fprint(os.Stderr, usage)
+    if fs != nil {
+        fs.PrintDefaults()
+    }
+}
+
+// 创建工厂并加载配置文件, 返回每个分片的错误
+func load(opts *options, file string) (*zdbfactory.DBFactory, []error) {
+    fopts := []zdbfactory.Options{
+        zdbfactory.WithDBPrefix(opts.prefix),
+        zdbfactory.WithConfigSection(opts.section),
+    }
+    if opts.profile != "" {
+        fopts = append(fopts, zdbfactory.WithProfile(opts.profile))
+    }
+    if opts.keyFile != "" {
+        key, err := zdbfactory.LoadCipherKeyFile(opts.keyFile)
+        if err != nil {
+            return nil, []error{err}
+        }
+        if opts.keyID == "" {
+            return nil, []error{fmt.Errorf("指定-key时必须指定-keyid")}
+        }
+        fopts = append(fopts, zdbfactory.WithCipherKey(opts.keyID, key))
+    }
+
+    factory := zdbfactory.New(fopts...)
+    var err error
+    switch strings.ToLower(filepath.Ext(file)) {
+    case ".toml":
+        err = factory.AddTomlFile(file)
+    case ".json":
+        err = factory.AddJSONFile(file)
+    case ".yaml", ".yml":
+        err = factory.AddYAMLFile(file)
+    default:
+        err = factory.AddViperFile(file, "")
+    }
+
+    if errs, ok := err.(zdbfactory.ShardErrors); ok {
+        return factory, errs
+    }
+    if err != nil {
+        return factory, []error{err}
+    }
+    return factory, nil
+}
+
+func printErrors(errs []error) {
+    for _, err := range errs {
+        fmt.Fprintln(os.Stderr, "error:", err)
+    }
+}
+
+func validate(opts *options, file string) bool {
+    factory, errs := load(opts, file)
+    if len(errs) > 0 {
+        printErrors(errs)
+        return false
+    }
+    fmt.Printf("ok, %d个分片\n", len(factory.ListDBs()))
+    return true
+}
+
+func list(opts *options, file string) bool {
+    factory, errs := load(opts, file)
+    if len(errs) > 0 {
+        printErrors(errs)
+        return false
+    }
+
+    bs, err := zdbfactory.MarshalDump(factory.DumpConfig(), zdbfactory.DumpFormat(opts.format))
+    if err != nil {
+        printErrors([]error{err})
+        return false
+    }
+    fmt.Println(string(bs))
+    return true
+}
+
+// ping结果
+type pingResult struct {
+    dbname  string
+    dbtype  zdbfactory.DBType
+    connect time.Duration
+    ping    time.Duration
+    err     error
+}
+
+func ping(opts *options, file string, dbnames []string) bool {
+    factory, errs := load(opts, file)
+    if len(errs) > 0 {
+        printErrors(errs)
+        return false
+    }
+
+    dbs := factory.ListDBs()
+    types := make(map[string]zdbfactory.DBType, len(dbs))
+    for _, db := range dbs {
+        types[db.DBName] = db.DBType
+    }
+    if len(dbnames) == 0 {
+        for _, db := range dbs {
+            dbnames = append(dbnames, db.DBName)
+        }
+    }
+
+    // 每个db使用单独的工厂并发探测, 一个db卡住不会影响其它db
+    results := make([]chan *pingResult, len(dbnames))
+    for i, dbname := range dbnames {
+        dbname = strings.ToLower(dbname)
+        results[i] = make(chan *pingResult, 1)
+        if _, ok := types[dbname]; !ok {
+            results[i] <- &pingResult{dbname: dbname, err: fmt.Errorf("不存在的dbname<%s>", dbname)}
+            continue
+        }
+        go func(dbname string, out chan<- *pingResult) {
+            f, _ := load(opts, file)
+            defer f.CloseAllDb()
+            out <- pingOne(f, dbname, types[dbname], opts.timeout)
+        }(dbname, results[i])
+    }
+
+    // 连接和ping各自最多等待timeout, 所有结果共用一个截止时间, 超时后剩下没有结果的db都视为超时
+    ctx, cancel := context.WithTimeout(context.Background(), opts.timeout*2)
+    defer cancel()
+
+    ok := true
+    for i, ch := range results {
+        var r *pingResult
+        select {
+        case r = <-ch:
+        case <-ctx.Done():
+            r = &pingResult{dbname: strings.ToLower(dbnames[i]), err: fmt.Errorf("超时")}
+        }
+
+        if r.err != nil {
+            ok = false
+            fmt.Printf("FAIL  %-20s %-15s %v\n", r.dbname, r.dbtype, r.err)
+            continue
+        }
+        fmt.Printf("OK    %-20s %-15s connect=%v ping=%v\n", r.dbname, r.dbtype, r.connect, r.ping)
+    }
+    return ok
+}
+
+func pingOne(factory *zdbfactory.DBFactory, dbname string, dbtype zdbfactory.DBType, timeout time.Duration) *pingResult {
+    r := &pingResult{dbname: dbname, dbtype: dbtype}
+
+    // 先连接依赖的db和虚拟db的成员, connect包含它们的连接耗时.
+    // 连接不支持context, 超时后不再等待, 连接完成后实例会被CloseAllDb丢弃
+    connectCtx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    done := make(chan error, 1)
+    start := time.Now()
+    go func() {
+        done <- factory.ConnectDBContext(connectCtx, dbname)
+    }()
+    select {
+    case r.err = <-done:
+    case <-connectCtx.Done():
+        r.err = fmt.Errorf("连接超时(%v)", timeout)
+    }
+    if r.err != nil {
+        return r
+    }
+    r.connect = time.Since(start)
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    r.ping, r.err = factory.Ping(ctx, dbname)
+    if r.err == zdbfactory.ErrPingNotSupported {
+        r.err = nil
+    }
+    return r
+}
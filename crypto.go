@@ -0,0 +1,138 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/27
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "io"
+    "io/ioutil"
+    "strings"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 加密值的前缀, 完整格式为 enc:v1:密钥id:base64(nonce+密文)
+const EncryptedValuePrefix = "enc:v1:"
+
+// 判断是否为加密值
+func isEncryptedValue(s string) bool {
+    return strings.HasPrefix(s, EncryptedValuePrefix)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, zerrors.WrapSimple(err, "无效的密钥")
+    }
+    return cipher.NewGCM(block)
+}
+
+// 使用AES-GCM加密一个值, key的长度必须为16, 24或32字节
+func EncryptValue(keyID string, key []byte, plaintext string) (string, error) {
+    if keyID == "" || strings.Contains(keyID, ":") {
+        return "", zerrors.NewSimple("密钥id不能为空且不能包含冒号")
+    }
+
+    gcm, err := newGCM(key)
+    if err != nil {
+        return "", err
+    }
+
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+        return "", err
+    }
+
+    sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(keyID))
+    return EncryptedValuePrefix + keyID + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// 解密一个由EncryptValue加密的值, keys为 密钥id:密钥
+//
+// 错误信息中不会包含密文和明文
+func DecryptValue(keys map[string][]byte, value string) (string, error) {
+    if !isEncryptedValue(value) {
+        return "", zerrors.NewSimple("不是加密值")
+    }
+
+    parts := strings.SplitN(value[len(EncryptedValuePrefix):], ":", 2)
+    if len(parts) != 2 {
+        return "", zerrors.NewSimple("加密值格式错误")
+    }
+    keyID := parts[0]
+
+    key, ok := keys[keyID]
+    if !ok {
+        return "", zerrors.NewSimplef("未知的密钥id<%s>", keyID)
+    }
+
+    sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil {
+        return "", zerrors.NewSimplef("密钥id<%s>的加密值格式错误", keyID)
+    }
+
+    gcm, err := newGCM(key)
+    if err != nil {
+        return "", err
+    }
+    if len(sealed) < gcm.NonceSize() {
+        return "", zerrors.NewSimplef("密钥id<%s>的加密值格式错误", keyID)
+    }
+
+    nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+    plain, err := gcm.Open(nil, nonce, ciphertext, []byte(keyID))
+    if err != nil {
+        return "", zerrors.NewSimplef("使用密钥id<%s>解密失败", keyID)
+    }
+    return string(plain), nil
+}
+
+// 生成一个32字节的随机密钥
+func GenerateCipherKey() ([]byte, error) {
+    key := make([]byte, 32)
+    if _, err := io.ReadFull(rand.Reader, key); err != nil {
+        return nil, err
+    }
+    return key, nil
+}
+
+// 从文件加载密钥, 文件内容为hex编码的密钥
+func LoadCipherKeyFile(file string) ([]byte, error) {
+    bs, err := ioutil.ReadFile(file)
+    if err != nil {
+        return nil, zerrors.WrapSimplef(err, "无法读取密钥文件<%s>", file)
+    }
+    key, err := hex.DecodeString(strings.TrimSpace(string(bs)))
+    if err != nil {
+        return nil, zerrors.NewSimplef("密钥文件<%s>不是hex编码", file)
+    }
+    switch len(key) {
+    case 16, 24, 32:
+        return key, nil
+    }
+    return nil, zerrors.NewSimplef("密钥文件<%s>的密钥长度必须为16, 24或32字节", file)
+}
+
+// 添加解密密钥, 重复的密钥id会被替换掉
+func (m *DBFactory) AddCipherKey(keyID string, key []byte) {
+    m.mx.Lock()
+    m.cipherKeys[keyID] = key
+    m.mx.Unlock()
+}
+
+// 解密配置中的加密值
+func (m *DBFactory) decryptValue(value string) (string, error) {
+    m.mx.RLock()
+    defer m.mx.RUnlock()
+    return DecryptValue(m.cipherKeys, value)
+}
@@ -0,0 +1,116 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/27
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+)
+
+func TestEncryptDecryptValue(t *testing.T) {
+    key16 := bytes.Repeat([]byte{1}, 16)
+    key24 := bytes.Repeat([]byte{2}, 24)
+    key32 := bytes.Repeat([]byte{3}, 32)
+
+    tests := []struct {
+        name      string
+        keyID     string
+        key       []byte
+        plaintext string
+    }{
+        {name: "aes128", keyID: "k1", key: key16, plaintext: "secret"},
+        {name: "aes192", keyID: "k2", key: key24, plaintext: "p@ss:word/with?symbols"},
+        {name: "aes256", keyID: "k3", key: key32, plaintext: "密码"},
+        {name: "empty", keyID: "k3", key: key32, plaintext: ""},
+        {name: "long", keyID: "prod-2020", key: key32, plaintext: strings.Repeat("x", 4096)},
+    }
+    keys := map[string][]byte{"k1": key16, "k2": key24, "k3": key32, "prod-2020": key32}
+    for _, tt := range tests {
+        value, err := EncryptValue(tt.keyID, tt.key, tt.plaintext)
+        if err != nil {
+            t.Errorf("%s: EncryptValue err = %v", tt.name, err)
+            continue
+        }
+        if !strings.HasPrefix(value, EncryptedValuePrefix+tt.keyID+":") {
+            t.Errorf("%s: EncryptValue = %q, want prefix %q", tt.name, value, EncryptedValuePrefix+tt.keyID+":")
+        }
+        if tt.plaintext != "" && strings.Contains(value, tt.plaintext) {
+            t.Errorf("%s: EncryptValue contains the plaintext", tt.name)
+        }
+
+        got, err := DecryptValue(keys, value)
+        if err != nil {
+            t.Errorf("%s: DecryptValue err = %v", tt.name, err)
+            continue
+        }
+        if got != tt.plaintext {
+            t.Errorf("%s: DecryptValue = %q, want %q", tt.name, got, tt.plaintext)
+        }
+    }
+}
+
+func TestEncryptValueErrors(t *testing.T) {
+    tests := []struct {
+        name  string
+        keyID string
+        key   []byte
+    }{
+        {name: "empty key id", keyID: "", key: make([]byte, 32)},
+        {name: "key id with colon", keyID: "a:b", key: make([]byte, 32)},
+        {name: "short key", keyID: "k", key: make([]byte, 15)},
+    }
+    for _, tt := range tests {
+        if _, err := EncryptValue(tt.keyID, tt.key, "secret"); err == nil {
+            t.Errorf("%s: EncryptValue err = nil, want error", tt.name)
+        }
+    }
+}
+
+func TestDecryptValueErrors(t *testing.T) {
+    key := bytes.Repeat([]byte{1}, 32)
+    value, err := EncryptValue("k1", key, "secret")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 改为另一个密钥id, 密钥id参与了认证, 即使密钥相同也无法解密
+    renamed := strings.Replace(value, EncryptedValuePrefix+"k1:", EncryptedValuePrefix+"k2:", 1)
+    // 篡改密文的第一个字符
+    i := len(EncryptedValuePrefix + "k1:")
+    c := byte('A')
+    if value[i] == c {
+        c = 'B'
+    }
+    tampered := value[:i] + string(c) + value[i+1:]
+
+    tests := []struct {
+        name  string
+        keys  map[string][]byte
+        value string
+    }{
+        {name: "not encrypted", keys: map[string][]byte{"k1": key}, value: "secret"},
+        {name: "missing payload", keys: map[string][]byte{"k1": key}, value: EncryptedValuePrefix + "k1"},
+        {name: "unknown key id", keys: map[string][]byte{"k2": key}, value: value},
+        {name: "wrong key", keys: map[string][]byte{"k1": bytes.Repeat([]byte{2}, 32)}, value: value},
+        {name: "renamed key id", keys: map[string][]byte{"k1": key, "k2": key}, value: renamed},
+        {name: "tampered", keys: map[string][]byte{"k1": key}, value: tampered},
+        {name: "bad base64", keys: map[string][]byte{"k1": key}, value: EncryptedValuePrefix + "k1:***"},
+        {name: "too short", keys: map[string][]byte{"k1": key}, value: EncryptedValuePrefix + "k1:AAAA"},
+    }
+    for _, tt := range tests {
+        _, err := DecryptValue(tt.keys, tt.value)
+        if err == nil {
+            t.Errorf("%s: DecryptValue err = nil, want error", tt.name)
+            continue
+        }
+        if strings.Contains(err.Error(), "secret") {
+            t.Errorf("%s: DecryptValue err = %q contains the plaintext", tt.name, err)
+        }
+    }
+}
@@ -9,6 +9,10 @@
 package zdbfactory
 
 import (
+    "context"
+    "io"
+    "net/http"
+
     "github.com/pelletier/go-toml"
     "github.com/spf13/viper"
 )
@@ -40,6 +44,11 @@ func AddTomlShard(dbname string, shard *toml.Tree) error {
     return defaultDBFactory.AddTomlShard(dbname, shard)
 }
 
+// 添加url形式的db配置, 重复的db名会被替换掉, url的格式见URLField
+func AddDBURL(dbname, rawurl string) error {
+    return defaultDBFactory.AddDBURL(dbname, rawurl)
+}
+
 // 添加db配置, 重复的db名会被替换掉
 func AddDBConfig(dbname string, dbtype DBType, config interface{}) {
     defaultDBFactory.AddDBConfig(dbname, dbtype, config)
@@ -50,12 +59,17 @@ func RemoveDB(dbname string) {
     defaultDBFactory.RemoveDB(dbname)
 }
 
-// 连接所有db
+// 连接所有db, 被依赖的db会先连接
 func ConnectAllDB() error {
     return defaultDBFactory.ConnectAllDB()
 }
 
-// 关闭所有db连接
+// 连接所有db, 连接失败后按重试策略重试, ctx结束时停止重试
+func ConnectAllDBContext(ctx context.Context) error {
+    return defaultDBFactory.ConnectAllDBContext(ctx)
+}
+
+// 关闭所有db连接, 按连接顺序的逆序关闭
 func CloseAllDb() {
     defaultDBFactory.CloseAllDb()
 }
@@ -64,3 +78,83 @@ func CloseAllDb() {
 func GetDBInstance(dbname string) *DBInstance {
     return defaultDBFactory.GetDBInstance(dbname)
 }
+
+// 获取db实例的句柄, 使用完后必须调用Release
+func Acquire(dbname string) (*DBHandle, error) {
+    return defaultDBFactory.Acquire(dbname)
+}
+
+// 获取分片组中key对应的成员实例
+func GetShard(group, key string) (*DBInstance, error) {
+    return defaultDBFactory.GetShard(group, key)
+}
+
+// 注册钩子
+func AddHook(event HookEvent, fn HookFunc) {
+    defaultDBFactory.AddHook(event, fn)
+}
+
+// 注册密钥提供者, 重复的名字会被替换掉
+func RegisterSecretProvider(name string, provider ISecretProvider) {
+    defaultDBFactory.RegisterSecretProvider(name, provider)
+}
+
+// 重新解析dbname的密钥并在后台重建实例
+func RotateCredentials(dbname string) error {
+    return defaultDBFactory.RotateCredentials(dbname)
+}
+
+// 添加解密密钥, 重复的密钥id会被替换掉
+func AddCipherKey(keyID string, key []byte) {
+    defaultDBFactory.AddCipherKey(keyID, key)
+}
+
+// 列出所有db的状态, 按db名排序
+func ListDBs() []*DBStatus {
+    return defaultDBFactory.ListDBs()
+}
+
+// 导出所有db的配置, 按db名排序, 敏感字段和来自密钥的字段的值会被隐藏
+func DumpConfig() []*DBConfigDump {
+    return defaultDBFactory.DumpConfig()
+}
+
+// 创建管理接口, 挂载方式为 http.Handle(AdminPrefix+"/", handler)
+func AdminHandler(auth AdminAuthFunc) http.Handler {
+    return defaultDBFactory.AdminHandler(auth)
+}
+
+// 添加json文件, 重复的db名会被替换掉
+func AddJSONFile(file string) error {
+    return defaultDBFactory.AddJSONFile(file)
+}
+
+// 从reader中读取json并添加, 重复的db名会被替换掉
+func AddJSONReader(r io.Reader) error {
+    return defaultDBFactory.AddJSONReader(r)
+}
+
+// 添加json分片, 重复的db名会被替换掉
+func AddJSONShard(dbname string, data []byte) error {
+    return defaultDBFactory.AddJSONShard(dbname, data)
+}
+
+// 添加yaml文件, 重复的db名会被替换掉
+func AddYAMLFile(file string) error {
+    return defaultDBFactory.AddYAMLFile(file)
+}
+
+// 从reader中读取yaml并添加, 重复的db名会被替换掉
+func AddYAMLReader(r io.Reader) error {
+    return defaultDBFactory.AddYAMLReader(r)
+}
+
+// 添加yaml分片, 重复的db名会被替换掉
+func AddYAMLShard(dbname string, data []byte) error {
+    return defaultDBFactory.AddYAMLShard(dbname, data)
+}
+
+// 添加etcd配置源, conf和etcd类型的分片配置相同
+func AddEtcdSource(conf *EtcdConfig, prefix string) (*EtcdSource, error) {
+    return defaultDBFactory.AddEtcdSource(conf, prefix)
+}
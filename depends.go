@@ -0,0 +1,185 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/10
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "sort"
+    "strings"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 分片依赖的dbname列表, 可以是数组或逗号分隔的字符串
+//
+// ConnectAllDB会先连接被依赖的db, CloseAllDb会先关闭依赖了其它db的db. 虚拟db的成员也会被视为它的依赖
+const DependsOnField = "depends_on"
+
+// 解析分片的depends_on, 返回小写的dbname列表
+func parseDependsOn(dbname string, shard map[string]interface{}) ([]string, error) {
+    v, ok := shardField(shard, DependsOnField)
+    if !ok {
+        return nil, nil
+    }
+
+    var names []string
+    switch vv := v.(type) {
+    case string:
+        names = strings.Split(vv, ",")
+    case []string:
+        names = vv
+    case []interface{}:
+        for _, item := range vv {
+            name, ok := item.(string)
+            if !ok {
+                return nil, zerrors.NewSimplef("<%s>的%s必须为字符串或字符串数组", dbname, DependsOnField)
+            }
+            names = append(names, name)
+        }
+    default:
+        return nil, zerrors.NewSimplef("<%s>的%s必须为字符串或字符串数组", dbname, DependsOnField)
+    }
+
+    out := make([]string, 0, len(names))
+    for _, name := range names {
+        name = strings.ToLower(strings.TrimSpace(name))
+        if name == "" {
+            continue
+        }
+        if name == dbname {
+            return nil, zerrors.NewSimplef("<%s>不能依赖自己", dbname)
+        }
+        if !containsString(out, name) {
+            out = append(out, name)
+        }
+    }
+    return out, nil
+}
+
+// 需要先于这个db连接的db, 包括depends_on和虚拟db的成员
+func (c *dbConfig) orderDeps() []string {
+    v, ok := c.config.(iVirtualConfig)
+    if !ok {
+        return c.dependsOn
+    }
+    members := v.members()
+    deps := make([]string, 0, len(c.dependsOn)+len(members))
+    deps = append(deps, c.dependsOn...)
+    return append(deps, members...)
+}
+
+// db和它直接或间接依赖的db, 调用者需要持有锁
+func (m *DBFactory) dependencyClosure(dbname string) map[string]bool {
+    seen := make(map[string]bool)
+    var visit func(name string)
+    visit = func(name string) {
+        if seen[name] {
+            return
+        }
+        seen[name] = true
+        if c, ok := m.confs[name]; ok {
+            for _, dep := range c.orderDeps() {
+                visit(dep)
+            }
+        }
+    }
+    visit(dbname)
+    return seen
+}
+
+// 按依赖关系排序所有db名, 被依赖的db在前, 没有依赖关系的db按名字排序
+//
+// 存在循环依赖或依赖了不存在的db时返回错误, 此时仍会返回忽略了出错依赖的顺序. 调用者需要持有锁
+func (m *DBFactory) dependencyOrder() ([]string, error) {
+    dbnames := make([]string, 0, len(m.confs))
+    for dbname := range m.confs {
+        dbnames = append(dbnames, dbname)
+    }
+    sort.Strings(dbnames)
+
+    const (
+        visiting = 1
+        visited  = 2
+    )
+    state := make(map[string]int, len(dbnames))
+    order := make([]string, 0, len(dbnames))
+    var errs ShardErrors
+
+    var visit func(dbname string, chain []string)
+    visit = func(dbname string, chain []string) {
+        switch state[dbname] {
+        case visiting:
+            errs = append(errs, zerrors.NewSimplef("循环依赖: %s -> %s", strings.Join(chain, " -> "), dbname))
+            return
+        case visited:
+            return
+        }
+
+        state[dbname] = visiting
+        chain = append(chain, dbname)
+        for _, dep := range m.confs[dbname].orderDeps() {
+            if _, ok := m.confs[dep]; !ok {
+                errs = append(errs, zerrors.NewSimplef("<%s>依赖了不存在的db<%s>", dbname, dep))
+                continue
+            }
+            visit(dep, chain)
+        }
+        state[dbname] = visited
+        order = append(order, dbname)
+    }
+    for _, dbname := range dbnames {
+        visit(dbname, nil)
+    }
+
+    if len(errs) > 0 {
+        return order, errs
+    }
+    return order, nil
+}
+
+// 检查用conf替换dbname的配置后依赖是否有效, 依赖必须存在, 并且不能产生循环依赖
+//
+// connected为true时depends_on中的db还必须已连接. 调用者需要持有锁
+func (m *DBFactory) checkDeps(dbname string, conf *dbConfig, connected bool) error {
+    seen := make(map[string]bool)
+    var reaches func(name string) bool
+    reaches = func(name string) bool {
+        if name == dbname {
+            return true
+        }
+        if seen[name] {
+            return false
+        }
+        seen[name] = true
+        if c, ok := m.confs[name]; ok {
+            for _, dep := range c.orderDeps() {
+                if reaches(dep) {
+                    return true
+                }
+            }
+        }
+        return false
+    }
+
+    for _, dep := range conf.orderDeps() {
+        if _, ok := m.confs[dep]; !ok {
+            return zerrors.NewSimplef("<%s>依赖了不存在的db<%s>", dbname, dep)
+        }
+        if reaches(dep) {
+            return zerrors.NewSimplef("<%s>依赖<%s>会产生循环依赖", dbname, dep)
+        }
+    }
+    if connected {
+        for _, dep := range conf.dependsOn {
+            if _, ok := m.storage[dep]; !ok {
+                return zerrors.NewSimplef("依赖的db<%s>未连接", dep)
+            }
+        }
+    }
+    return nil
+}
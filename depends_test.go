@@ -0,0 +1,97 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/10
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestDependencyOrder(t *testing.T) {
+    dep := func(names ...string) *dbConfig {
+        return &dbConfig{dbtype: Redis, dependsOn: names}
+    }
+
+    tests := []struct {
+        name    string
+        confs   map[string]*dbConfig
+        want    []string
+        wantErr string // 错误信息中应包含的内容, 为空时不应返回错误
+    }{
+        {
+            name:  "no dependencies are sorted by name",
+            confs: map[string]*dbConfig{"c": dep(), "a": dep(), "b": dep()},
+            want:  []string{"a", "b", "c"},
+        },
+        {
+            name:  "chain",
+            confs: map[string]*dbConfig{"a": dep("b"), "b": dep("c"), "c": dep()},
+            want:  []string{"c", "b", "a"},
+        },
+        {
+            name:  "diamond",
+            confs: map[string]*dbConfig{"app": dep("cache", "db"), "cache": dep("db"), "db": dep(), "z": dep()},
+            want:  []string{"db", "cache", "app", "z"},
+        },
+        {
+            name: "virtual db members",
+            confs: map[string]*dbConfig{
+                "group": {dbtype: Failover, config: &FailoverConfig{Members: []string{"S2", "s1"}}},
+                "s1":    dep(),
+                "s2":    dep(),
+            },
+            want: []string{"s2", "s1", "group"},
+        },
+        {
+            name:    "cycle",
+            confs:   map[string]*dbConfig{"a": dep("b"), "b": dep("a")},
+            want:    []string{"b", "a"},
+            wantErr: "循环依赖: a -> b -> a",
+        },
+        {
+            name:    "cycle of three",
+            confs:   map[string]*dbConfig{"a": dep("b"), "b": dep("c"), "c": dep("a"), "d": dep()},
+            want:    []string{"c", "b", "a", "d"},
+            wantErr: "循环依赖: a -> b -> c -> a",
+        },
+        {
+            name: "cycle through a virtual db",
+            confs: map[string]*dbConfig{
+                "group": {dbtype: Failover, config: &FailoverConfig{Members: []string{"s1"}}},
+                "s1":    dep("group"),
+            },
+            want:    []string{"s1", "group"},
+            wantErr: "循环依赖: group -> s1 -> group",
+        },
+        {
+            name:    "missing dependency",
+            confs:   map[string]*dbConfig{"a": dep("nope"), "b": dep("a")},
+            want:    []string{"a", "b"},
+            wantErr: "<a>依赖了不存在的db<nope>",
+        },
+    }
+    for _, tt := range tests {
+        m := New()
+        m.confs = tt.confs
+
+        got, err := m.dependencyOrder()
+        switch {
+        case tt.wantErr == "" && err != nil:
+            t.Errorf("%s: err = %v, want nil", tt.name, err)
+        case tt.wantErr != "" && err == nil:
+            t.Errorf("%s: err = nil, want %q", tt.name, tt.wantErr)
+        case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+            t.Errorf("%s: err = %q, want it to contain %q", tt.name, err, tt.wantErr)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: order = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
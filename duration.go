@@ -0,0 +1,71 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/12
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 时间长度, 单位为毫秒
+//
+// 在配置中可以直接写毫秒数, 如 3000
+// 也可以写带单位的字符串, 如 "500ms", "3s", "1m"
+//
+// 不兼容变更: 各配置结构的超时字段从int64改为Duration, 使用常量赋值(如 ReadTimeout: 3000)的代码不受影响,
+// 使用int64变量赋值的代码需要改为 Duration(v), 读取字段作为int64使用的代码需要改为 int64(d) 或 d.Duration()
+type Duration int64
+
+// 转为time.Duration
+func (d Duration) Duration() time.Duration {
+    return time.Duration(d) * time.Millisecond
+}
+
+// 转为秒, 不足1秒的部分向上取整
+func (d Duration) Seconds() int {
+    if d <= 0 {
+        return 0
+    }
+    return int((d + 999) / 1000)
+}
+
+// 解析时间长度, 纯数字表示毫秒, 带单位时必须是整数毫秒, 如500us会返回错误
+func ParseDuration(s string) (Duration, error) {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return 0, zerrors.NewSimple("时间长度为空")
+    }
+
+    if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+        return Duration(n), nil
+    }
+
+    d, err := time.ParseDuration(s)
+    if err != nil {
+        return 0, zerrors.NewSimplef("无法解析的时间长度<%s>", s)
+    }
+    if d%time.Millisecond != 0 {
+        return 0, zerrors.NewSimplef("时间长度<%s>的精度不能小于毫秒", s)
+    }
+    return Duration(d / time.Millisecond), nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// 将字符串转为Duration的解码钩子
+func durationDecodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+    if to != durationType || from.Kind() != reflect.String {
+        return data, nil
+    }
+    return ParseDuration(data.(string))
+}
@@ -0,0 +1,99 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/12
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseDuration(t *testing.T) {
+    tests := []struct {
+        in      string
+        want    Duration
+        wantErr bool
+    }{
+        {in: "3000", want: 3000},
+        {in: "0", want: 0},
+        {in: "500ms", want: 500},
+        {in: "3s", want: 3000},
+        {in: "1.5s", want: 1500},
+        {in: "1m", want: 60000},
+        {in: "1h2m", want: 3720000},
+        {in: " 2s ", want: 2000},
+        {in: "", wantErr: true},
+        {in: "   ", wantErr: true},
+        {in: "abc", wantErr: true},
+        {in: "3 s", wantErr: true},
+        {in: "1000us", want: 1},
+        {in: "500us", wantErr: true},
+        {in: "1.5ms", wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := ParseDuration(tt.in)
+        if (err != nil) != tt.wantErr {
+            t.Errorf("ParseDuration(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+            continue
+        }
+        if got != tt.want {
+            t.Errorf("ParseDuration(%q) = %d, want %d", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestDurationConvert(t *testing.T) {
+    tests := []struct {
+        in          Duration
+        wantTime    time.Duration
+        wantSeconds int
+    }{
+        {in: 0, wantTime: 0, wantSeconds: 0},
+        {in: -5, wantTime: -5 * time.Millisecond, wantSeconds: 0},
+        {in: 1, wantTime: time.Millisecond, wantSeconds: 1},
+        {in: 1000, wantTime: time.Second, wantSeconds: 1},
+        {in: 1001, wantTime: 1001 * time.Millisecond, wantSeconds: 2},
+    }
+    for _, tt := range tests {
+        if got := tt.in.Duration(); got != tt.wantTime {
+            t.Errorf("Duration(%d).Duration() = %v, want %v", tt.in, got, tt.wantTime)
+        }
+        if got := tt.in.Seconds(); got != tt.wantSeconds {
+            t.Errorf("Duration(%d).Seconds() = %d, want %d", tt.in, got, tt.wantSeconds)
+        }
+    }
+}
+
+func TestMakeConfigDuration(t *testing.T) {
+    tests := []struct {
+        name    string
+        value   interface{}
+        want    Duration
+        wantErr bool
+    }{
+        {name: "int", value: 3000, want: 3000},
+        {name: "int64", value: int64(250), want: 250},
+        {name: "string millis", value: "1200", want: 1200},
+        {name: "string unit", value: "3s", want: 3000},
+        {name: "invalid", value: "soon", wantErr: true},
+    }
+    m := New()
+    for _, tt := range tests {
+        config, err := m.makeConfig(Redis, map[string]interface{}{"ReadTimeout": tt.value})
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+            continue
+        }
+        if tt.wantErr {
+            continue
+        }
+        if conf := config.(*RedisConfig); conf.ReadTimeout != tt.want {
+            t.Errorf("%s: ReadTimeout = %d, want %d", tt.name, conf.ReadTimeout, tt.want)
+        }
+    }
+}
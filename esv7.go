@@ -10,7 +10,7 @@ package zdbfactory
 
 import (
     "context"
-    "time"
+    "net/http"
 
     "github.com/olivere/elastic/v7"
     "github.com/zlyuancn/zerrors"
@@ -19,16 +19,18 @@ import (
 type esv7Factory int
 
 var _ IDBFactory = (*esv7Factory)(nil)
+var _ iDBFactoryWithPlugins = (*esv7Factory)(nil)
+var _ IDBPinger = (*esv7Factory)(nil)
 
 type ESv7Config struct {
     Address       []string // 地址
     UserName      string   // 用户名
     Password      string   // 密码
-    DialTimeout   int64    // 连接超时(毫秒
+    DialTimeout   Duration // 连接超时(毫秒
     Sniff         bool     // 嗅探器
     Healthcheck   bool     // 心跳检查
     Retry         int      // 重试次数
-    RetryInterval int      // 重试间隔(毫秒)
+    RetryInterval Duration // 重试间隔(毫秒)
     GZip          bool     // 启用gzip压缩
 }
 
@@ -36,7 +38,15 @@ func (esv7Factory) MakeEmptyConfig() interface{} {
     return new(ESv7Config)
 }
 
-func (esv7Factory) Connect(config interface{}) (interface{}, error) {
+func (m esv7Factory) Connect(config interface{}) (interface{}, error) {
+    return m.connect(config, nil)
+}
+
+func (m esv7Factory) connectWithPlugins(info *connectInfo, plugins []instancePlugin) (interface{}, error) {
+    return m.connect(info.config, pluginTransport(info, plugins))
+}
+
+func (esv7Factory) connect(config interface{}, transport http.RoundTripper) (interface{}, error) {
     var conf *ESv7Config
     switch c := config.(type) {
     case *ESv7Config:
@@ -53,20 +63,25 @@ func (esv7Factory) Connect(config interface{}) (interface{}, error) {
         elastic.SetHealthcheck(conf.Healthcheck),
         elastic.SetGzip(conf.GZip),
     }
+    if transport != nil {
+        opts = append(opts, elastic.SetHttpClient(&http.Client{Transport: transport}))
+    }
     if conf.UserName != "" || conf.Password != "" {
         opts = append(opts, elastic.SetBasicAuth(conf.UserName, conf.Password))
     }
     if conf.Retry > 0 {
         ticks := make([]int, conf.Retry)
         for i := 0; i < conf.Retry; i++ {
-            ticks[i] = conf.RetryInterval
+            ticks[i] = int(conf.RetryInterval)
         }
         elastic.SetRetrier(elastic.NewBackoffRetrier(elastic.NewSimpleBackoff(ticks...)))
     }
 
     ctx := context.Background()
     if conf.DialTimeout > 0 {
-        ctx, _ = context.WithTimeout(ctx, time.Duration(conf.DialTimeout*1e6))
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, conf.DialTimeout.Duration())
+        defer cancel()
     }
 
     c, err := elastic.DialContext(ctx, opts...)
@@ -76,6 +91,16 @@ func (esv7Factory) Connect(config interface{}) (interface{}, error) {
 
     return c, nil
 }
+func (esv7Factory) Ping(ctx context.Context, dbinstance interface{}) error {
+    c, ok := dbinstance.(*elastic.Client)
+    if !ok {
+        return zerrors.NewSimple("非*elastic.Client结构")
+    }
+
+    _, err := c.ClusterHealth().Do(ctx)
+    return err
+}
+
 func (esv7Factory) Close(dbinstance interface{}) error {
     c, ok := dbinstance.(*elastic.Client)
     if !ok {
@@ -95,7 +120,7 @@ func AddEsv7Config(dbname string, conf *ESv7Config) {
 func GetESv7(dbname string) (*elastic.Client, error) {
     a := defaultDBFactory.GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != ESv7 {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.dbtype)
@@ -10,7 +10,6 @@ package zdbfactory
 
 import (
     "context"
-    "time"
 
     "github.com/zlyuancn/zerrors"
     "go.etcd.io/etcd/clientv3"
@@ -19,13 +18,14 @@ import (
 type etcdFactory int
 
 var _ IDBFactory = (*etcdFactory)(nil)
+var _ IDBPinger = (*etcdFactory)(nil)
 
 type EtcdConfig struct {
     Address     []string
-    UserName    string // 用户名
-    Password    string // 密码
-    DialTimeout int64  // 连接超时(毫秒
-    Ping        bool   // 开始连接时是否ping确认连接情况
+    UserName    string   // 用户名
+    Password    string   // 密码
+    DialTimeout Duration // 连接超时(毫秒
+    Ping        bool     // 开始连接时是否ping确认连接情况
 }
 
 func (etcdFactory) MakeEmptyConfig() interface{} {
@@ -47,7 +47,7 @@ func (etcdFactory) Connect(config interface{}) (interface{}, error) {
         Endpoints:   conf.Address,
         Username:    conf.UserName,
         Password:    conf.Password,
-        DialTimeout: time.Duration(conf.DialTimeout * 1e6),
+        DialTimeout: conf.DialTimeout.Duration(),
     })
     if err != nil {
         return nil, zerrors.WrapSimple(err, "连接失败")
@@ -61,6 +61,16 @@ func (etcdFactory) Connect(config interface{}) (interface{}, error) {
 
     return c, nil
 }
+func (etcdFactory) Ping(ctx context.Context, dbinstance interface{}) error {
+    c, ok := dbinstance.(*clientv3.Client)
+    if !ok {
+        return zerrors.NewSimple("非*clientv3.Client结构")
+    }
+
+    _, err := c.Get(ctx, "/")
+    return err
+}
+
 func (etcdFactory) Close(dbinstance interface{}) error {
     c, ok := dbinstance.(*clientv3.Client)
     if !ok {
@@ -79,7 +89,7 @@ func AddEtcdConfig(dbname string, conf *EtcdConfig) {
 func GetEtcd(dbname string) (*clientv3.Client, error) {
     a := defaultDBFactory.GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != ETCD {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.dbtype)
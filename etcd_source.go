@@ -0,0 +1,343 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/4
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "bytes"
+    "context"
+    "path"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/pelletier/go-toml"
+    "github.com/zlyuancn/zerrors"
+    "go.etcd.io/etcd/clientv3"
+)
+
+// etcd配置源重新同步失败, 以及重试应用失败的分片的间隔
+const EtcdSourceRetryInterval = time.Second * 5
+
+// etcd配置源, 从etcd的key前缀中加载分片并监听变化
+//
+// 前缀下每个key是一个分片, key的最后一段以分片前缀(默认为zdb_)开头, 如 /config/db/zdb_main.
+// 值为json(以{开头)或toml格式的分片. 分片之间相互独立, 不支持extends和模板
+type EtcdSource struct {
+    factory *DBFactory
+    client  *clientv3.Client
+    prefix  string
+    dbnames map[string]struct{}               // 由这个配置源添加的db
+    pending map[string]map[string]interface{} // 应用失败等待重试的分片
+    cancel  context.CancelFunc
+    done    chan struct{}
+    mx      sync.Mutex
+}
+
+// 添加etcd配置源, conf和etcd类型的分片配置相同
+//
+// 添加时会加载前缀下所有的分片, 任何分片出错时不会添加任何分片并返回ShardErrors, 初始加载的分片需要调用ConnectAllDB连接.
+// 之后在后台监听前缀, 已经调用过ConnectAllDB时新增的分片会被直接连接, 否则只会添加配置,
+// 修改的分片会用新配置重建已连接的实例, 删除的分片会被移除.
+// 修改时会检查依赖, 依赖无效, 解析密钥失败或连接失败时保留原来的配置和实例, 并每隔EtcdSourceRetryInterval重试.
+// 可选db连接失败时会添加配置并在后台重试连接, 和ConnectAllDB一样
+func (m *DBFactory) AddEtcdSource(conf *EtcdConfig, prefix string) (*EtcdSource, error) {
+    c, err := etcdFactory(0).Connect(conf)
+    if err != nil {
+        return nil, zerrors.WrapSimple(err, "etcd配置源连接失败")
+    }
+
+    s := &EtcdSource{
+        factory: m,
+        client:  c.(*clientv3.Client),
+        prefix:  prefix,
+        dbnames: make(map[string]struct{}),
+        pending: make(map[string]map[string]interface{}),
+        done:    make(chan struct{}),
+    }
+
+    rev, err := s.load(true)
+    if err != nil {
+        _ = s.client.Close()
+        return nil, err
+    }
+
+    var ctx context.Context
+    ctx, s.cancel = context.WithCancel(context.Background())
+    go s.watch(ctx, rev)
+
+    m.log.Info("已添加etcd配置源", F("prefix", prefix), F("count", len(s.dbnames)))
+    return s, nil
+}
+
+// 停止监听并关闭etcd客户端, 已添加的db不会被移除
+func (s *EtcdSource) Close() error {
+    s.cancel()
+    <-s.done
+    return s.client.Close()
+}
+
+// 加载前缀下的所有分片, 返回加载时的版本号
+//
+// initial为false时表示重新同步, 分片会像监听到变化一样逐个应用, 不存在的分片会被移除
+func (s *EtcdSource) load(initial bool) (int64, error) {
+    resp, err := s.client.Get(context.Background(), s.prefix, clientv3.WithPrefix())
+    if err != nil {
+        return 0, zerrors.WrapSimplef(err, "从etcd加载<%s>失败", s.prefix)
+    }
+
+    var errs ShardErrors
+    shards := make(map[string]map[string]interface{})
+    for _, kv := range resp.Kvs {
+        dbname, ok := s.dbname(kv.Key)
+        if !ok {
+            continue
+        }
+        shard, err := decodeEtcdShard(kv.Value)
+        if err != nil {
+            errs = append(errs, zerrors.WrapSimplef(err, "<%s>解析失败", kv.Key))
+            continue
+        }
+        shards[dbname] = shard
+    }
+
+    if initial {
+        if len(errs) > 0 {
+            return 0, errs
+        }
+        if err = s.factory.addShards(shards); err != nil {
+            return 0, err
+        }
+        s.mx.Lock()
+        for dbname := range shards {
+            s.dbnames[dbname] = struct{}{}
+        }
+        s.mx.Unlock()
+        return resp.Header.Revision, nil
+    }
+
+    for _, err := range errs {
+        s.factory.log.Error("etcd配置源的分片解析失败", F("prefix", s.prefix), F("error", err.Error()))
+    }
+    for dbname, shard := range shards {
+        s.put(dbname, shard)
+    }
+    s.mx.Lock()
+    var removed []string
+    for dbname := range s.dbnames {
+        if _, ok := shards[dbname]; !ok {
+            removed = append(removed, dbname)
+        }
+    }
+    for dbname := range s.pending {
+        if _, ok := shards[dbname]; !ok {
+            delete(s.pending, dbname)
+        }
+    }
+    s.mx.Unlock()
+    for _, dbname := range removed {
+        s.remove(dbname)
+    }
+    return resp.Header.Revision, nil
+}
+
+// 监听前缀, 监听出错(如版本被压缩)时重新同步, 并定期重试应用失败的分片
+func (s *EtcdSource) watch(ctx context.Context, rev int64) {
+    defer close(s.done)
+
+    retry := time.NewTicker(EtcdSourceRetryInterval)
+    defer retry.Stop()
+
+    for ctx.Err() == nil {
+        rev = s.watchOnce(ctx, rev, retry.C)
+        if ctx.Err() != nil {
+            return
+        }
+
+        newRev, err := s.load(false)
+        if err != nil {
+            s.factory.log.Error("etcd配置源重新同步失败", F("prefix", s.prefix), F("error", err.Error()))
+            select {
+            case <-ctx.Done():
+            case <-time.After(EtcdSourceRetryInterval):
+            }
+            continue
+        }
+        rev = newRev
+    }
+}
+
+// 监听直到出错或ctx结束, 返回最后应用的版本号
+func (s *EtcdSource) watchOnce(ctx context.Context, rev int64, retry <-chan time.Time) int64 {
+    wch := s.client.Watch(ctx, s.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+    for {
+        select {
+        case wresp, ok := <-wch:
+            if !ok {
+                return rev
+            }
+            if err := wresp.Err(); err != nil {
+                s.factory.log.Warn("etcd配置源监听出错", F("prefix", s.prefix), F("error", err.Error()))
+                return rev
+            }
+            for _, ev := range wresp.Events {
+                s.apply(ev)
+            }
+            rev = wresp.Header.Revision
+        case <-retry:
+            s.retryPending()
+        }
+    }
+}
+
+// 重试应用失败的分片
+func (s *EtcdSource) retryPending() {
+    s.mx.Lock()
+    pending := make(map[string]map[string]interface{}, len(s.pending))
+    for dbname, shard := range s.pending {
+        pending[dbname] = shard
+    }
+    s.mx.Unlock()
+
+    for dbname, shard := range pending {
+        s.put(dbname, shard)
+    }
+}
+
+// 应用一个变化
+func (s *EtcdSource) apply(ev *clientv3.Event) {
+    dbname, ok := s.dbname(ev.Kv.Key)
+    if !ok {
+        return
+    }
+
+    if ev.Type == clientv3.EventTypeDelete {
+        s.remove(dbname)
+        return
+    }
+
+    shard, err := decodeEtcdShard(ev.Kv.Value)
+    if err != nil {
+        s.factory.log.Error("etcd配置源的分片解析失败", F("key", string(ev.Kv.Key)), F("error", err.Error()))
+        return
+    }
+    s.put(dbname, shard)
+}
+
+// 添加或替换分片, 出错时保留原来的配置和实例, 并在之后重试
+func (s *EtcdSource) put(dbname string, shard map[string]interface{}) {
+    err := s.tryPut(dbname, shard)
+
+    s.mx.Lock()
+    if err == nil {
+        delete(s.pending, dbname)
+    } else {
+        s.pending[dbname] = shard
+    }
+    s.mx.Unlock()
+
+    if err != nil {
+        s.factory.log.Error("etcd配置源的分片应用失败, 保留原来的配置并稍后重试", F("dbname", dbname), F("error", err.Error()))
+    }
+}
+
+func (s *EtcdSource) tryPut(dbname string, shard map[string]interface{}) error {
+    m := s.factory
+
+    // 重新同步时大部分分片没有变化, 不需要重建实例
+    m.mx.RLock()
+    old, ok := m.confs[dbname]
+    m.mx.RUnlock()
+    if ok && reflect.DeepEqual(old.raw, shard) {
+        s.mx.Lock()
+        s.dbnames[dbname] = struct{}{}
+        s.mx.Unlock()
+        return nil
+    }
+
+    conf, err := m.buildDBConfig(dbname, shard)
+    if err != nil {
+        return err
+    }
+
+    m.mx.Lock()
+    _, connected := m.storage[dbname]
+    // 已经调用过ConnectAllDB时未连接的db也需要连接, 依赖的db必须已连接
+    connect := !connected && m.connecting
+
+    if err = m.checkDeps(dbname, conf, connected); err != nil {
+        m.mx.Unlock()
+        return err
+    }
+    if connect {
+        err = m.checkDeps(dbname, conf, true)
+    }
+    var oldInstance *DBInstance
+    var event *HookContext
+    if err == nil {
+        // 连接会在锁外进行
+        oldInstance, event, err = m.swapDBConfig(dbname, conf, connect)
+    }
+    if err != nil && connect && conf.optional {
+        m.log.Warn("可选db连接失败, 将在后台重试", F("dbname", dbname), F("dbtype", conf.dbtype), F("error", err.Error()))
+        m.lastErrors[dbname] = err
+        oldInstance, event, err = m.swapDBConfig(dbname, conf, false)
+        m.retryOptional(dbname, conf)
+    }
+    m.mx.Unlock()
+    if err != nil {
+        return zerrors.WrapSimple(err, "连接实例失败")
+    }
+    _ = m.triggerHook(event)
+
+    s.mx.Lock()
+    s.dbnames[dbname] = struct{}{}
+    s.mx.Unlock()
+
+    m.log.Info("etcd配置源的分片已更新", F("dbname", dbname), F("dbtype", conf.dbtype), F("config", redactConfig(conf.config, conf.secretKeys...)))
+    if oldInstance != nil {
+        m.closeLater(dbname, oldInstance, m.drainTime)
+    }
+    return nil
+}
+
+// 移除由这个配置源添加的分片, 实例会在后台等待句柄释放后关闭, 不会阻塞监听
+func (s *EtcdSource) remove(dbname string) {
+    s.mx.Lock()
+    _, ok := s.dbnames[dbname]
+    delete(s.dbnames, dbname)
+    delete(s.pending, dbname)
+    s.mx.Unlock()
+
+    if ok {
+        s.factory.RemoveDB(dbname)
+    }
+}
+
+// 从key中获取dbname, key的最后一段不是分片时返回false
+func (s *EtcdSource) dbname(key []byte) (string, bool) {
+    name := path.Base(strings.TrimPrefix(string(key), s.prefix))
+    prefix := s.factory.layout.prefix
+    if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+        return "", false
+    }
+    return strings.ToLower(name[len(prefix):]), true
+}
+
+// 解析etcd中的分片, 以{开头的值为json, 否则为toml
+func decodeEtcdShard(value []byte) (map[string]interface{}, error) {
+    if bytes.HasPrefix(bytes.TrimSpace(value), []byte("{")) {
+        return decodeJSON(value)
+    }
+    tree, err := toml.LoadBytes(value)
+    if err != nil {
+        return nil, err
+    }
+    return tree.ToMap(), nil
+}
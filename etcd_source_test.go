@@ -0,0 +1,181 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/4
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "testing"
+    "time"
+)
+
+// 没有etcd客户端的配置源, 只用于直接应用分片
+func newTestEtcdSource(m *DBFactory) *EtcdSource {
+    return &EtcdSource{
+        factory: m,
+        dbnames: make(map[string]struct{}),
+        pending: make(map[string]map[string]interface{}),
+    }
+}
+
+func TestEtcdSourcePut(t *testing.T) {
+    fake := func(fields ...interface{}) map[string]interface{} {
+        shard := map[string]interface{}{"dbtype": string(fakeDB)}
+        for i := 0; i < len(fields); i += 2 {
+            shard[fields[i].(string)] = fields[i+1]
+        }
+        return shard
+    }
+
+    tests := []struct {
+        name          string
+        connectAll    bool                              // 应用分片前是否调用过ConnectAllDB
+        put           map[string]map[string]interface{} // 应用的分片, 按c, b的顺序应用
+        wantConnected []string
+        wantConfigs   []string
+        wantPending   []string
+    }{
+        {
+            name:        "new shard before ConnectAllDB is only added",
+            put:         map[string]map[string]interface{}{"b": fake()},
+            wantConfigs: []string{"a", "b"},
+            wantPending: []string{},
+        },
+        {
+            name:          "new shard after ConnectAllDB is connected",
+            connectAll:    true,
+            put:           map[string]map[string]interface{}{"b": fake("depends_on", []interface{}{"a"})},
+            wantConnected: []string{"a", "b"},
+            wantConfigs:   []string{"a", "b"},
+            wantPending:   []string{},
+        },
+        {
+            name:          "connect failure keeps the shard pending",
+            connectAll:    true,
+            put:           map[string]map[string]interface{}{"b": fake("fail", true)},
+            wantConnected: []string{"a"},
+            wantConfigs:   []string{"a"},
+            wantPending:   []string{"b"},
+        },
+        {
+            name:          "optional connect failure adds the config",
+            connectAll:    true,
+            put:           map[string]map[string]interface{}{"b": fake("fail", true, "optional", true)},
+            wantConnected: []string{"a"},
+            wantConfigs:   []string{"a", "b"},
+            wantPending:   []string{},
+        },
+        {
+            name:          "dependency not connected",
+            connectAll:    true,
+            put:           map[string]map[string]interface{}{"b": fake("depends_on", []interface{}{"c"}), "c": fake("fail", true, "optional", true)},
+            wantConnected: []string{"a"},
+            wantConfigs:   []string{"a", "c"},
+            wantPending:   []string{"b"},
+        },
+        {
+            name:          "missing dependency",
+            connectAll:    true,
+            put:           map[string]map[string]interface{}{"b": fake("depends_on", []interface{}{"nope"})},
+            wantConnected: []string{"a"},
+            wantConfigs:   []string{"a"},
+            wantPending:   []string{"b"},
+        },
+    }
+    for _, tt := range tests {
+        m := New()
+        m.optionalRetry = time.Hour
+        m.AddDBConfig("a", fakeDB, &fakeConfig{})
+        if tt.connectAll {
+            if err := m.ConnectAllDB(); err != nil {
+                t.Fatalf("%s: ConnectAllDB: %v", tt.name, err)
+            }
+        }
+
+        s := newTestEtcdSource(m)
+        for _, dbname := range []string{"c", "b"} {
+            if shard, ok := tt.put[dbname]; ok {
+                s.put(dbname, shard)
+            }
+        }
+
+        var connected, configs, pending []string
+        for _, dbname := range []string{"a", "b", "c"} {
+            if m.GetDBInstance(dbname) != nil {
+                connected = append(connected, dbname)
+            }
+            if _, ok := m.confs[dbname]; ok {
+                configs = append(configs, dbname)
+            }
+            if _, ok := s.pending[dbname]; ok {
+                pending = append(pending, dbname)
+            }
+        }
+        check := func(what string, got, want []string) {
+            if len(got) != len(want) {
+                t.Errorf("%s: %s = %v, want %v", tt.name, what, got, want)
+                return
+            }
+            for i := range got {
+                if got[i] != want[i] {
+                    t.Errorf("%s: %s = %v, want %v", tt.name, what, got, want)
+                    return
+                }
+            }
+        }
+        check("connected", connected, tt.wantConnected)
+        check("configs", configs, tt.wantConfigs)
+        check("pending", pending, tt.wantPending)
+        m.CloseAllDb()
+    }
+}
+
+func TestEtcdSourceReplaceAndRemove(t *testing.T) {
+    m := New(WithDrainTime(0))
+    s := newTestEtcdSource(m)
+    s.put("a", map[string]interface{}{"dbtype": string(fakeDB)})
+    if err := m.ConnectAllDB(); err != nil {
+        t.Fatal(err)
+    }
+    old := fakeConnOf(t, m, "a")
+
+    // 没有变化的分片不会重建实例
+    s.put("a", map[string]interface{}{"dbtype": string(fakeDB)})
+    if fakeConnOf(t, m, "a") != old {
+        t.Error("unchanged shard rebuilt the instance")
+    }
+
+    // 连接失败时保留原来的实例并稍后重试
+    s.put("a", map[string]interface{}{"dbtype": string(fakeDB), "fail": true})
+    if fakeConnOf(t, m, "a") != old || len(s.pending) != 1 {
+        t.Errorf("failed replace: instance changed or pending = %v", s.pending)
+    }
+
+    s.put("a", map[string]interface{}{"dbtype": string(fakeDB), "closedelay": int(time.Millisecond)})
+    if fakeConnOf(t, m, "a") == old || len(s.pending) != 0 {
+        t.Errorf("replace: instance not rebuilt or pending = %v", s.pending)
+    }
+    if !waitFor(time.Second, old.isClosed) {
+        t.Error("replaced instance is not closed")
+    }
+
+    current := fakeConnOf(t, m, "a")
+    s.remove("a")
+    if m.GetDBInstance("a") != nil || len(m.confs) != 0 {
+        t.Error("removed shard is still in the factory")
+    }
+    if !waitFor(time.Second, current.isClosed) {
+        t.Error("removed instance is not closed")
+    }
+
+    // 不是这个配置源添加的db不会被移除
+    m.AddDBConfig("b", fakeDB, &fakeConfig{})
+    s.remove("b")
+    if _, ok := m.confs["b"]; !ok {
+        t.Error("remove deleted a db added by another source")
+    }
+}
@@ -9,10 +9,15 @@
 package zdbfactory
 
 import (
+    "context"
     "fmt"
+    "os"
+    "reflect"
     "strings"
     "sync"
+    "time"
 
+    "github.com/mitchellh/mapstructure"
     "github.com/pelletier/go-toml"
     "github.com/spf13/viper"
     "github.com/zlyuancn/zerrors"
@@ -33,7 +38,7 @@ const (
     KafkaProducer        = "kafka_producer"
 )
 
-// 解析配置树中以DBPrefix开头的分片
+// 默认解析配置树中以DBPrefix开头的分片, 可以用WithDBPrefix修改
 const DBPrefix = "zdb_"
 
 // 这个字段表示db类型, 它必须在toml分片中存在
@@ -41,8 +46,15 @@ const DBTypeField = "dbtype"
 
 // db实例
 type DBInstance struct {
-    dbtype   DBType
-    instance interface{}
+    dbtype      DBType
+    instance    interface{}
+    connectTime time.Time
+    config      interface{} // 连接时使用的配置, 关闭时传给钩子
+    refs        refCounter  // 未释放的句柄数
+}
+
+func newDBInstance(conf *dbConfig, instance interface{}) *DBInstance {
+    return &DBInstance{dbtype: conf.dbtype, instance: instance, config: conf.config, connectTime: time.Now()}
 }
 
 // 获取db类型
@@ -55,18 +67,38 @@ func (m *DBInstance) Instance() interface{} {
     return m.instance
 }
 
+// 获取连接成功的时间
+func (m *DBInstance) ConnectTime() time.Time {
+    return m.connectTime
+}
+
 type dbConfig struct {
-    dbtype DBType
-    config interface{}
+    dbtype     DBType
+    config     interface{}
+    raw        map[string]interface{} // 原始分片, 通过AddDBConfig添加的配置为nil
+    secretKeys []string               // 值来自密钥提供者的字段(小写), 输出时需要隐藏
+    secretRefs []string               // 使用到的密钥引用, 格式为 提供者名:引用
+    dependsOn  []string               // 依赖的dbname
+    optional   bool                   // 是否为可选的db
+    retry      *RetryPolicy           // 连接重试策略, 为nil时使用工厂的策略
+    breaker    *circuitBreaker        // 熔断器, 为nil时不启用
+    limiter    *rateLimiter           // 限流器, 为nil时不启用
 }
 
 type IDBFactory interface {
     // 构建一个空的配置结构, 返回值必须是一个指针
+    //
+    // 分片按字段名(不区分大小写)解码到这个结构中, 结构只使用了toml标签时按toml标签解码, 否则按mapstructure标签解码
     MakeEmptyConfig() interface{}
     Connect(config interface{}) (c interface{}, err error)
     Close(dbinstance interface{}) error
 }
 
+// 支持ping的factory, 用于健康检查
+type IDBPinger interface {
+    Ping(ctx context.Context, dbinstance interface{}) error
+}
+
 var factoryStorage = map[DBType]IDBFactory{
     Mongo:         new(mongoFactory),
     Redis:         new(redisFactory),
@@ -76,26 +108,63 @@ var factoryStorage = map[DBType]IDBFactory{
     SSDB:          new(ssdbFactory),
     ETCD:          new(etcdFactory),
     KafkaProducer: new(kafkaProducerFactory),
+    Failover:      new(failoverFactory),
+    Sharded:       new(shardedFactory),
 }
 
 type DBFactory struct {
-    storage   map[string]*DBInstance
-    confs     map[string]*dbConfig
-    autoClose bool
-    mx        sync.RWMutex
+    storage         map[string]*DBInstance
+    confs           map[string]*dbConfig
+    autoClose       bool
+    metrics         *factoryMetrics
+    plugins         []instancePlugin
+    log             ILogger
+    hooks           map[HookEvent][]HookFunc
+    hookMx          sync.RWMutex               // 保护hooks, 连接时会在工厂的锁外触发钩子
+    secretProviders map[string]ISecretProvider // 密钥提供者
+    cipherKeys      map[string][]byte          // 解密配置中加密值的密钥
+    connected       map[string]struct{}        // 连接成功过的db名, 用于判断是否为重连
+    lastErrors      map[string]error           // 每个db最后一次连接失败的错误, 连接成功后清除
+    retrying        map[string]*dbConfig       // 正在后台重试连接的可选db
+    optionalRetry   time.Duration              // 可选db的后台重试间隔
+    retryPolicy     *RetryPolicy               // ConnectAllDB的连接重试策略
+    drainTime       time.Duration              // 实例被替换后等待多久再关闭旧实例
+    releaseTimeout  time.Duration              // 关闭实例前等待句柄释放的最长时间
+    layout          configLayout               // 从配置树中找出分片的方式
+    closeGen        int                        // CloseAllDb的调用次数, 连接期间变化时丢弃新实例
+    connecting      bool                       // 调用过ConnectAllDB并且之后没有调用CloseAllDb, 配置源新增的分片会直接连接
+    mx              sync.RWMutex
 }
 
 // 创建一个db工厂
 func New(opts ...Options) *DBFactory {
     factory := &DBFactory{
-        storage: make(map[string]*DBInstance),
-        confs:   make(map[string]*dbConfig),
+        storage:        make(map[string]*DBInstance),
+        confs:          make(map[string]*dbConfig),
+        log:            nopLogger{},
+        hooks:          make(map[HookEvent][]HookFunc),
+        connected:      make(map[string]struct{}),
+        lastErrors:     make(map[string]error),
+        retrying:       make(map[string]*dbConfig),
+        drainTime:      DefaultDrainTime,
+        releaseTimeout: DefaultReleaseTimeout,
+        optionalRetry:  DefaultOptionalRetryInterval,
+        cipherKeys:     make(map[string][]byte),
+        layout:         configLayout{prefix: DBPrefix},
+        secretProviders: map[string]ISecretProvider{
+            "env":  EnvSecretProvider{},
+            "file": FileSecretProvider{},
+        },
     }
 
     for _, o := range opts {
         o(factory)
     }
 
+    if factory.layout.profile == "" {
+        factory.layout.profile = os.Getenv(ProfileEnv)
+    }
+
     if factory.autoClose {
         zsignal.RegisterOnShutdown(factory.CloseAllDb)
     }
@@ -113,39 +182,19 @@ func (m *DBFactory) AddViperFile(file, filetype string) error {
     if err := v.ReadInConfig(); err != nil {
         return err
     }
+    m.log.Info("加载配置文件", F("file", file))
     return m.AddViperTree(v)
 }
 
-// 添加viper树
+// 添加viper树, 重复的db名会被替换掉
+//
+// 任何分片出错时不会添加任何分片, 返回的错误为ShardErrors
 func (m *DBFactory) AddViperTree(tree *viper.Viper) error {
-    for key, shard := range tree.AllSettings() {
-        if !strings.HasPrefix(key, DBPrefix) {
-            continue
-        }
-
-        switch mm := shard.(type) {
-        case map[string]interface{}:
-            dbname := key[len(DBPrefix):]
-
-            switch dbtype := mm[DBTypeField].(type) {
-            case string:
-                if dbtype == "" {
-                    return zerrors.NewSimplef("<%s>错误, %s为空", dbname, DBTypeField)
-                }
-
-                dbtype = strings.ToLower(dbtype)
-                config := m.mustGetFactory(DBType(dbtype)).MakeEmptyConfig()
-                if err := tree.UnmarshalKey(key, config); err != nil {
-                    return zerrors.WrapSimple(err, "配置结构解析失败")
-                }
-
-                m.AddDBConfig(dbname, DBType(dbtype), config)
-            default:
-                return zerrors.NewSimplef("<%s>错误, %s必须存在且为string类型", dbname, DBTypeField)
-            }
-        }
+    shards, err := m.findShards(tree.AllSettings())
+    if err != nil {
+        return err
     }
-    return nil
+    return m.addShards(shards)
 }
 
 // 添加toml文件, 重复的db名会被替换掉
@@ -154,126 +203,390 @@ func (m *DBFactory) AddTomlFile(file string) error {
     if err != nil {
         return zerrors.WrapSimple(err, "toml文件加载失败")
     }
+    m.log.Info("加载配置文件", F("file", file))
     return m.AddTomlTree(tree)
 }
 
 // 添加toml树, 重复的db名会被替换掉
+//
+// 任何分片出错时不会添加任何分片, 返回的错误为ShardErrors
 func (m *DBFactory) AddTomlTree(tree *toml.Tree) error {
-    for _, key := range tree.Keys() {
-        if !strings.HasPrefix(key, DBPrefix) {
-            continue
-        }
-        switch shard := tree.Get(key).(type) {
-        case *toml.Tree:
-            dbname := key[len(DBPrefix):]
-            if err := m.AddTomlShard(dbname, shard); err != nil {
-                return err
-            }
-        }
+    shards, err := m.findShards(tree.ToMap())
+    if err != nil {
+        return err
     }
-    return nil
+    return m.addShards(shards)
 }
 
 // 添加toml分片, 重复的db名会被替换掉
 func (m *DBFactory) AddTomlShard(dbname string, shard *toml.Tree) error {
+    return m.addShard(dbname, shard.ToMap())
+}
+
+// 添加一个已解析为map的分片, 根据dbtype构建配置结构
+func (m *DBFactory) addShard(dbname string, shard map[string]interface{}) error {
     if dbname == "" {
         return zerrors.NewSimple("dbname为空")
     }
 
     dbname = strings.ToLower(dbname)
+    conf, err := m.buildDBConfig(dbname, shard)
+    if err != nil {
+        return err
+    }
 
-    switch dbtype := shard.Get(DBTypeField).(type) {
+    m.setDBConfig(dbname, conf)
+    return nil
+}
+
+// 根据分片构建db配置, 会解析分片中的密钥引用和url
+//
+// 错误信息中来自密钥的值会被隐藏
+func (m *DBFactory) buildDBConfig(dbname string, shard map[string]interface{}) (*dbConfig, error) {
+    resolved, err := m.resolveSecrets(dbname, shard)
+    if err != nil {
+        return nil, err
+    }
+    conf, err := m.buildResolvedConfig(dbname, shard, resolved)
+    if err != nil {
+        return nil, redactSecretError(err, resolved.secretValues)
+    }
+    return conf, nil
+}
+
+// 根据解析了密钥的分片构建db配置
+func (m *DBFactory) buildResolvedConfig(dbname string, shard map[string]interface{}, resolved *resolvedShard) (*dbConfig, error) {
+    fields, urlKeys, err := expandURLShard(dbname, resolved.shard)
+    if err != nil {
+        if containsString(resolved.secretKeys, URLField) {
+            return nil, zerrors.NewSimplef("<%s>的%s解析失败, 它来自密钥, 不显示详细错误", dbname, URLField)
+        }
+        return nil, err
+    }
+    // url来自密钥时, 从url中解析出的字段也视为来自密钥
+    if containsString(resolved.secretKeys, URLField) {
+        resolved.secretKeys = append(resolved.secretKeys, urlKeys...)
+        for _, key := range urlKeys {
+            v, _ := shardField(fields, key)
+            resolved.secretValues = append(resolved.secretValues, secretValues(v)...)
+        }
+    }
+
+    dependsOn, err := parseDependsOn(dbname, fields)
+    if err != nil {
+        return nil, err
+    }
+    optional, err := parseOptional(dbname, fields)
+    if err != nil {
+        return nil, err
+    }
+    retry, err := parseRetryPolicy(dbname, fields)
+    if err != nil {
+        return nil, err
+    }
+    fields = dropFields(fields, DependsOnField, OptionalField, ConnectRetryField)
+
+    rawType, _ := shardField(fields, DBTypeField)
+    switch dbtype := rawType.(type) {
     case string:
         if dbtype == "" {
-            return zerrors.NewSimplef("<%s>错误, %s为空", dbname, DBTypeField)
+            return nil, zerrors.NewSimplef("<%s>错误, %s为空", dbname, DBTypeField)
         }
 
         dbtype = strings.ToLower(dbtype)
-        config := m.mustGetFactory(DBType(dbtype)).MakeEmptyConfig()
-        if err := shard.Unmarshal(config); err != nil {
-            return err
+        if _, ok := factoryStorage[DBType(dbtype)]; !ok {
+            return nil, zerrors.NewSimplef("<%s>错误, 不支持的db类型<%s>", dbname, dbtype)
         }
 
-        m.AddDBConfig(dbname, DBType(dbtype), config)
-    default:
-        return zerrors.NewSimplef("<%s>错误, %s必须存在且为string类型", dbname, DBTypeField)
+        breaker, err := m.parseCircuitBreaker(dbname, DBType(dbtype), fields)
+        if err != nil {
+            return nil, err
+        }
+
+        limiter, err := parseRateLimit(dbname, DBType(dbtype), fields)
+        if err != nil {
+            return nil, err
+        }
+
+        config, err := m.makeConfig(DBType(dbtype), dropFields(fields, CircuitBreakerField, RateLimitField))
+        if err != nil {
+            return nil, zerrors.WrapSimplef(err, "<%s>配置结构解析失败", dbname)
+        }
+
+        return &dbConfig{
+            dbtype:     DBType(dbtype),
+            config:     config,
+            raw:        shard,
+            secretKeys: resolved.secretKeys,
+            secretRefs: resolved.secretRefs,
+            dependsOn:  dependsOn,
+            optional:   optional,
+            retry:      retry,
+            breaker:    breaker,
+            limiter:    limiter,
+        }, nil
     }
-    return nil
+    return nil, zerrors.NewSimplef("<%s>错误, %s必须存在且为string类型", dbname, DBTypeField)
+}
+
+// 将分片解码为dbtype对应的配置结构
+func (m *DBFactory) makeConfig(dbtype DBType, shard map[string]interface{}) (interface{}, error) {
+    config := m.mustGetFactory(dbtype).MakeEmptyConfig()
+    if err := decodeShard(shard, config); err != nil {
+        return nil, err
+    }
+    return config, nil
+}
+
+// 将分片解码到out中, out必须是一个指针
+//
+// 字段名不区分大小写. 结构体只使用了toml标签时按toml标签解码, 否则按mapstructure标签解码
+func decodeShard(shard map[string]interface{}, out interface{}) error {
+    decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+        DecodeHook: mapstructure.ComposeDecodeHookFunc(
+            durationDecodeHook,
+            mapstructure.StringToSliceHookFunc(","),
+        ),
+        WeaklyTypedInput: true,
+        TagName:          configTagName(reflect.TypeOf(out)),
+        Result:           out,
+    })
+    if err != nil {
+        return err
+    }
+    return decoder.Decode(shard)
+}
+
+// 配置结构使用的标签名, 自定义的配置结构可能只有toml标签
+func configTagName(t reflect.Type) string {
+    var hasToml, hasMapstructure bool
+    seen := make(map[reflect.Type]bool)
+    var walk func(t reflect.Type)
+    walk = func(t reflect.Type) {
+        for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+            t = t.Elem()
+        }
+        if t.Kind() != reflect.Struct || seen[t] {
+            return
+        }
+        seen[t] = true
+        for i := 0; i < t.NumField(); i++ {
+            field := t.Field(i)
+            if _, ok := field.Tag.Lookup("toml"); ok {
+                hasToml = true
+            }
+            if _, ok := field.Tag.Lookup("mapstructure"); ok {
+                hasMapstructure = true
+            }
+            walk(field.Type)
+        }
+    }
+    walk(t)
+
+    if hasToml && !hasMapstructure {
+        return "toml"
+    }
+    return "mapstructure"
+}
+
+// 添加url形式的db配置, 重复的db名会被替换掉, url的格式见URLField
+func (m *DBFactory) AddDBURL(dbname, rawurl string) error {
+    return m.addShard(dbname, map[string]interface{}{URLField: rawurl})
 }
 
 // 添加db配置, 重复的db名会被替换掉
 func (m *DBFactory) AddDBConfig(dbname string, dbtype DBType, config interface{}) {
-    dbname = strings.ToLower(dbname)
+    m.setDBConfig(strings.ToLower(dbname), &dbConfig{dbtype: dbtype, config: config})
+}
 
+// 设置db配置, 已存在的实例会立即被移除, 在后台等待它的句柄全部释放后关闭
+func (m *DBFactory) setDBConfig(dbname string, conf *dbConfig) {
     m.mx.Lock()
 
-    // 关闭之前的连接
+    // 之前的连接在后台等待句柄释放后关闭
     if instance, ok := m.storage[dbname]; ok {
-        _ = m.closeDB(instance)
         delete(m.storage, dbname)
+        m.closeLater(dbname, instance, 0)
     }
 
+    _, replaced := m.confs[dbname]
+
     // 设置新的配置
-    m.confs[dbname] = &dbConfig{
-        dbtype: dbtype,
-        config: config,
-    }
+    m.confs[dbname] = conf
 
     m.mx.Unlock()
+
+    event := ConfigAdded
+    if replaced {
+        event = ConfigReplaced
+    }
+    _ = m.triggerHook(&HookContext{Event: event, DBName: dbname, DBType: conf.dbtype, Config: conf.config})
+
+    if replaced {
+        m.log.Info("db配置已替换", F("dbname", dbname), F("dbtype", conf.dbtype), F("config", redactConfig(conf.config, conf.secretKeys...)))
+    } else {
+        m.log.Info("db配置已添加", F("dbname", dbname), F("dbtype", conf.dbtype), F("config", redactConfig(conf.config, conf.secretKeys...)))
+    }
 }
 
-// 移除db, 移除之前会关闭连接
+// 移除db, 实例会立即被移除, 在后台等待它的句柄全部释放后关闭, 最多等待releaseTimeout
 func (m *DBFactory) RemoveDB(dbname string) {
     dbname = strings.ToLower(dbname)
 
     m.mx.Lock()
 
     if instance, ok := m.storage[dbname]; ok {
-        _ = m.closeDB(instance)
         delete(m.storage, dbname)
+        m.closeLater(dbname, instance, 0)
     }
 
+    conf, ok := m.confs[dbname]
     delete(m.confs, dbname)
+    delete(m.lastErrors, dbname)
+    delete(m.retrying, dbname)
 
     m.mx.Unlock()
+
+    if ok {
+        _ = m.triggerHook(&HookContext{Event: ConfigRemoved, DBName: dbname, DBType: conf.dbtype, Config: conf.config})
+        m.log.Info("db已移除", F("dbname", dbname), F("dbtype", conf.dbtype))
+    }
 }
 
-// 连接所有db
+// 连接所有db, 被依赖的db会先连接, 存在循环依赖或依赖了不存在的db时不会连接任何db
+//
+// 可选db连接失败时不会返回错误, 它会在后台重试, 依赖了未连接的db的db视为连接失败
 func (m *DBFactory) ConnectAllDB() error {
+    return m.ConnectAllDBContext(context.Background())
+}
+
+// 连接所有db, 和ConnectAllDB相同, 连接失败后按重试策略重试, ctx结束时停止重试
+func (m *DBFactory) ConnectAllDBContext(ctx context.Context) error {
     m.mx.Lock()
-    for dbname, conf := range m.confs {
+    defer m.mx.Unlock()
+
+    order, err := m.dependencyOrder()
+    if err != nil {
+        return err
+    }
+    m.connecting = true
+    return m.connectInOrder(ctx, order)
+}
+
+// 连接db和它依赖的db(包括虚拟db的成员), 被依赖的db会先连接, 已连接的db不会重新连接
+//
+// 和ConnectAllDBContext相同, 可选db连接失败时不会返回错误, 它会在后台重试
+func (m *DBFactory) ConnectDBContext(ctx context.Context, dbname string) error {
+    dbname = strings.ToLower(dbname)
+
+    m.mx.Lock()
+    defer m.mx.Unlock()
+
+    if _, ok := m.confs[dbname]; !ok {
+        return zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+    }
+    order, err := m.dependencyOrder()
+    if err != nil {
+        return err
+    }
+
+    deps := m.dependencyClosure(dbname)
+    scoped := make([]string, 0, len(deps))
+    for _, name := range order {
+        if deps[name] {
+            scoped = append(scoped, name)
+        }
+    }
+    return m.connectInOrder(ctx, scoped)
+}
+
+// 按顺序连接db, 跳过已连接的db, 调用者需要持有锁
+func (m *DBFactory) connectInOrder(ctx context.Context, order []string) error {
+    for _, dbname := range order {
         if _, ok := m.storage[dbname]; ok {
             continue
         }
 
-        instance, err := m.connectDB(conf)
-        if err != nil {
-            m.mx.Unlock()
+        // 等待重试期间会释放锁, db可能已被移除
+        conf, ok := m.confs[dbname]
+        if !ok {
+            continue
+        }
+        if err := m.connectWithRetry(ctx, dbname, conf); err != nil {
+            if conf.optional {
+                m.log.Warn("可选db连接失败, 将在后台重试", F("dbname", dbname), F("dbtype", conf.dbtype), F("error", err.Error()))
+                m.retryOptional(dbname, conf)
+                continue
+            }
             return fmt.Errorf("%s, %s", dbname, err)
         }
+    }
+    return nil
+}
 
-        m.storage[dbname] = &DBInstance{dbtype: conf.dbtype, instance: instance}
+// 连接db并保存实例, 依赖的db未连接时视为连接失败
+//
+// 调用者需要持有锁, 连接期间会释放锁. 连接期间其它调用者已保存了实例时会在锁外关闭新实例
+func (m *DBFactory) connectAndStore(dbname string, conf *dbConfig) error {
+    for _, dep := range conf.dependsOn {
+        if _, ok := m.storage[dep]; !ok {
+            err := zerrors.NewSimplef("依赖的db<%s>未连接", dep)
+            m.lastErrors[dbname] = err
+            return err
+        }
     }
-    m.mx.Unlock()
+
+    instance, err := m.connectDB(dbname, conf, conf)
+    if err != nil {
+        return err
+    }
+    if _, ok := m.storage[dbname]; ok {
+        m.mx.Unlock()
+        _ = m.closeDB(dbname, newDBInstance(conf, instance))
+        m.mx.Lock()
+        return nil
+    }
+    m.storage[dbname] = newDBInstance(conf, instance)
     return nil
 }
 
-// 关闭所有db连接
+// 关闭所有db连接, 按连接顺序的逆序关闭
+//
+// 每个实例关闭前会等待它的句柄全部释放, 所有实例共用releaseTimeout, 超时后剩下的实例会直接关闭
 func (m *DBFactory) CloseAllDb() {
     m.mx.Lock()
-    for _, instance := range m.storage {
-        _ = m.closeDB(instance)
+    // 先停止后台重试, 正在进行的连接完成后会被丢弃
+    m.retrying = make(map[string]*dbConfig)
+    m.closeGen++
+    m.connecting = false
+
+    deadline := time.Now().Add(m.releaseTimeout)
+    order, _ := m.dependencyOrder()
+    for i := len(order) - 1; i >= 0; i-- {
+        m.closeWhenReleased(order[i], deadline)
+    }
+    for len(m.storage) > 0 {
+        for dbname := range m.storage {
+            m.closeWhenReleased(dbname, deadline)
+            break
+        }
     }
-    m.storage = make(map[string]*DBInstance)
     m.mx.Unlock()
 }
 
 // 获取db实例, 不存在会返回nil
+//
+// 虚拟db(如failover)会返回它当前使用的成员的实例
 func (m *DBFactory) GetDBInstance(dbname string) *DBInstance {
     dbname = strings.ToLower(dbname)
 
     m.mx.RLock()
     out := m.storage[dbname]
+    if out != nil {
+        if v, ok := out.instance.(iVirtualInstance); ok {
+            out = m.storage[v.activeMember()]
+        }
+    }
     m.mx.RUnlock()
     return out
 }
@@ -285,11 +598,127 @@ func (m *DBFactory) mustGetFactory(dbtype DBType) IDBFactory {
     panic(zerrors.NewSimplef("不支持的db类型<%v>", dbtype))
 }
 
-func (m *DBFactory) connectDB(conf *dbConfig) (interface{}, error) {
-    return m.mustGetFactory(conf.dbtype).Connect(conf.config)
+// 连接db, 调用者需要持有锁, 连接期间会释放锁
+//
+// 连接后m.confs[dbname]必须仍然是expect(为nil表示不存在), 并且没有调用CloseAllDb, 否则会关闭新实例并返回错误
+func (m *DBFactory) connectDB(dbname string, conf, expect *dbConfig) (interface{}, error) {
+    gen := m.closeGen
+    m.mx.Unlock()
+    instance, latency, err := m.dial(dbname, conf)
+    m.mx.Lock()
+
+    if err == nil && (m.confs[dbname] != expect || m.closeGen != gen) {
+        m.mx.Unlock()
+        _ = m.closeDB(dbname, newDBInstance(conf, instance))
+        m.mx.Lock()
+        return nil, zerrors.NewSimplef("<%s>的配置在连接期间被修改或已关闭", dbname)
+    }
+
+    _, reconnect := m.connected[dbname]
+    m.metrics.observeConnect(dbname, conf.dbtype, latency, reconnect, err)
+    if err != nil {
+        m.log.Error("db连接失败", F("dbname", dbname), F("dbtype", conf.dbtype), F("latency", latency), F("error", err.Error()))
+        m.lastErrors[dbname] = err
+        return nil, err
+    }
+
+    m.connected[dbname] = struct{}{}
+    delete(m.lastErrors, dbname)
+    if reconnect {
+        m.log.Info("db已重连", F("dbname", dbname), F("dbtype", conf.dbtype), F("latency", latency))
+    } else {
+        m.log.Info("db已连接", F("dbname", dbname), F("dbtype", conf.dbtype), F("latency", latency))
+    }
+
+    return instance, nil
 }
-func (m *DBFactory) closeDB(instance *DBInstance) error {
-    return m.mustGetFactory(instance.dbtype).Close(instance.instance)
+
+// 建立连接并装配插件, 触发连接的钩子. 非虚拟db不需要持有锁
+func (m *DBFactory) dial(dbname string, conf *dbConfig) (interface{}, time.Duration, error) {
+    hctx := &HookContext{Event: BeforeConnect, DBName: dbname, DBType: conf.dbtype, Config: conf.config}
+    if err := m.triggerHook(hctx); err != nil {
+        return nil, 0, err
+    }
+
+    info := &connectInfo{dbname: dbname, dbtype: conf.dbtype, config: hctx.Config}
+    factory := m.mustGetFactory(conf.dbtype)
+    plugins := m.instancePlugins(conf)
+
+    start := time.Now()
+    var instance interface{}
+    var err error
+    if vf, ok := factory.(iVirtualDBFactory); ok {
+        // 虚拟db不会建立连接, 只需要在读锁内访问其它db
+        m.mx.RLock()
+        instance, err = vf.connectVirtual(m, info)
+        m.mx.RUnlock()
+    } else if pf, ok := factory.(iDBFactoryWithPlugins); ok && len(plugins) > 0 {
+        instance, err = pf.connectWithPlugins(info, plugins)
+    } else {
+        instance, err = factory.Connect(info.config)
+    }
+    latency := time.Since(start)
+    if err != nil {
+        return nil, latency, err
+    }
+
+    instance = applyPlugins(info, instance, plugins)
+
+    hctx.Event, hctx.Instance = AfterConnect, instance
+    if err = m.triggerHook(hctx); err != nil {
+        // 钩子可能已经替换了实例, 关闭当前的实例
+        if hctx.Instance != nil {
+            _ = factory.Close(hctx.Instance)
+        } else {
+            _ = factory.Close(instance)
+        }
+        return nil, latency, err
+    }
+    if err = checkReplacedInstance(instance, hctx.Instance); err != nil {
+        _ = factory.Close(instance)
+        return nil, latency, err
+    }
+    return hctx.Instance, latency, nil
+}
+
+// 获取装配db实例的插件, 包括工厂的插件和db自己的插件(限流和熔断器)
+//
+// redis的限制器按顺序调用, 限流在熔断器之前, 等待限流的请求不会占用熔断器半开时的名额
+func (m *DBFactory) instancePlugins(conf *dbConfig) []instancePlugin {
+    if conf.breaker == nil && conf.limiter == nil {
+        return m.plugins
+    }
+    plugins := make([]instancePlugin, 0, len(m.plugins)+2)
+    plugins = append(plugins, m.plugins...)
+    if conf.limiter != nil {
+        plugins = append(plugins, &rateLimitPlugin{limiter: conf.limiter})
+    }
+    if conf.breaker != nil {
+        plugins = append(plugins, &breakerPlugin{breaker: conf.breaker})
+    }
+    return plugins
+}
+
+// 关闭已从storage中移除或没有保存过的实例, 调用者不能持有锁, 慢关闭不会阻塞其它db
+func (m *DBFactory) closeDB(dbname string, instance *DBInstance) error {
+    hctx := &HookContext{Event: BeforeClose, DBName: dbname, DBType: instance.dbtype, Config: instance.config, Instance: instance.instance}
+    _ = m.triggerHook(hctx)
+
+    err := m.mustGetFactory(instance.dbtype).Close(instance.instance)
+
+    m.mx.RLock()
+    current, ok := m.storage[dbname]
+    m.mx.RUnlock()
+    m.metrics.observeClose(dbname, instance.dbtype, ok && current != instance, err)
+    if err != nil {
+        m.log.Error("db关闭失败", F("dbname", dbname), F("dbtype", instance.dbtype), F("error", err.Error()))
+    } else {
+        m.log.Debug("db已关闭", F("dbname", dbname), F("dbtype", instance.dbtype))
+    }
+
+    hctx.Event, hctx.Err = AfterClose, err
+    _ = m.triggerHook(hctx)
+    return err
 }
 
 // 注册自定义factory
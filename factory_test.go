@@ -0,0 +1,79 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/3/4
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "reflect"
+    "testing"
+)
+
+type tomlTaggedConfig struct {
+    Addr    string   `toml:"server_addr"`
+    Timeout Duration `toml:"timeout"`
+    Inner   struct {
+        Name string `toml:"inner_name"`
+    } `toml:"inner"`
+}
+
+type mapstructureTaggedConfig struct {
+    Addr string `mapstructure:"server_addr"`
+    Port int
+}
+
+type untaggedConfig struct {
+    ServerAddr string
+    PoolSize   int
+}
+
+func TestDecodeShardTags(t *testing.T) {
+    tests := []struct {
+        name  string
+        shard map[string]interface{}
+        out   interface{}
+        want  interface{}
+    }{
+        {
+            name:  "toml tags",
+            shard: map[string]interface{}{"server_addr": "h1", "timeout": "2s", "inner": map[string]interface{}{"inner_name": "n"}},
+            out:   new(tomlTaggedConfig),
+            want: func() *tomlTaggedConfig {
+                c := &tomlTaggedConfig{Addr: "h1", Timeout: 2000}
+                c.Inner.Name = "n"
+                return c
+            }(),
+        },
+        {
+            name:  "mapstructure tags",
+            shard: map[string]interface{}{"server_addr": "h1", "PORT": "80"},
+            out:   new(mapstructureTaggedConfig),
+            want:  &mapstructureTaggedConfig{Addr: "h1", Port: 80},
+        },
+        {
+            name:  "field names are case insensitive",
+            shard: map[string]interface{}{"serveraddr": "h1", "PoolSize": 3},
+            out:   new(untaggedConfig),
+            want:  &untaggedConfig{ServerAddr: "h1", PoolSize: 3},
+        },
+        {
+            name:  "built in config",
+            shard: map[string]interface{}{"address": "h1,h2", "readtimeout": "1s"},
+            out:   new(RedisConfig),
+            want:  &RedisConfig{Address: []string{"h1", "h2"}, ReadTimeout: 1000},
+        },
+    }
+    for _, tt := range tests {
+        if err := decodeShard(tt.shard, tt.out); err != nil {
+            t.Errorf("%s: err = %v", tt.name, err)
+            continue
+        }
+        if !reflect.DeepEqual(tt.out, tt.want) {
+            t.Errorf("%s: got %+v, want %+v", tt.name, tt.out, tt.want)
+        }
+    }
+}
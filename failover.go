@@ -0,0 +1,260 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/18
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 虚拟的db类型, 它没有自己的连接, 而是指向其它db
+const (
+    // 故障转移组, 获取实例时返回第一个健康的成员
+    Failover DBType = "failover"
+)
+
+// 故障转移组成员恢复后的策略
+const (
+    // 切回优先级更高的成员
+    FailbackPreferred = "preferred"
+    // 保持当前成员直到它不可用
+    FailbackSticky = "sticky"
+)
+
+// 故障转移组配置的默认值
+const (
+    DefaultFailoverCheckInterval    = time.Second * 5
+    DefaultFailoverCheckTimeout     = time.Second
+    DefaultFailoverFailureThreshold = 3
+)
+
+// 故障转移组配置
+type FailoverConfig struct {
+    Members          []string // 成员dbname, 按优先级排列, 成员必须是同一种db类型
+    CheckInterval    Duration // 健康检查间隔(毫秒), 默认为5秒
+    CheckTimeout     Duration // 每次ping的超时(毫秒), 默认为1秒
+    FailureThreshold int      // 连续失败多少次后视为不可用, 默认为3
+    Failback         string   // 成员恢复后的策略, 可选 preferred(默认) 和 sticky
+}
+
+func (c FailoverConfig) members() []string {
+    return lowerNames(c.Members)
+}
+
+// 虚拟db的配置, 它的成员会先于它连接, 后于它关闭
+type iVirtualConfig interface {
+    members() []string
+}
+
+// 虚拟db类型的factory, 连接时需要访问工厂中的其它db
+type iVirtualDBFactory interface {
+    // 在工厂的读锁内调用
+    connectVirtual(m *DBFactory, info *connectInfo) (interface{}, error)
+}
+
+// 可以解析为某个成员的虚拟db实例, GetDBInstance会返回这个成员的实例
+type iVirtualInstance interface {
+    activeMember() string
+}
+
+type failoverFactory int
+
+var _ IDBFactory = (*failoverFactory)(nil)
+var _ iVirtualDBFactory = (*failoverFactory)(nil)
+
+func (failoverFactory) MakeEmptyConfig() interface{} {
+    return new(FailoverConfig)
+}
+
+func (failoverFactory) Connect(config interface{}) (interface{}, error) {
+    return nil, zerrors.NewSimple("failover只能通过DBFactory连接")
+}
+
+func (failoverFactory) connectVirtual(m *DBFactory, info *connectInfo) (interface{}, error) {
+    var conf *FailoverConfig
+    switch c := info.config.(type) {
+    case *FailoverConfig:
+        conf = c
+    case FailoverConfig:
+        conf = &c
+    default:
+        return nil, zerrors.NewSimple("非*FailoverConfig结构")
+    }
+
+    members := conf.members()
+    if len(members) == 0 {
+        return nil, zerrors.NewSimple("failover的members为空")
+    }
+    if _, err := m.checkMembers(members); err != nil {
+        return nil, err
+    }
+
+    g := &failoverGroup{
+        dbname:    info.dbname,
+        factory:   m,
+        members:   members,
+        interval:  conf.CheckInterval.Duration(),
+        timeout:   conf.CheckTimeout.Duration(),
+        threshold: conf.FailureThreshold,
+        sticky:    strings.EqualFold(conf.Failback, FailbackSticky),
+        failures:  make(map[string]int, len(members)),
+        stop:      make(chan struct{}),
+    }
+    if g.interval <= 0 {
+        g.interval = DefaultFailoverCheckInterval
+    }
+    if g.timeout <= 0 {
+        g.timeout = DefaultFailoverCheckTimeout
+    }
+    if g.threshold <= 0 {
+        g.threshold = DefaultFailoverFailureThreshold
+    }
+
+    // 初始时使用第一个已连接的成员
+    for _, member := range members {
+        if _, ok := m.storage[member]; ok {
+            g.active = member
+            break
+        }
+    }
+    if g.active == "" {
+        return nil, zerrors.NewSimple("failover没有已连接的成员")
+    }
+
+    go g.monitor()
+    return g, nil
+}
+
+func (failoverFactory) Close(dbinstance interface{}) error {
+    g, ok := dbinstance.(*failoverGroup)
+    if !ok {
+        return zerrors.NewSimple("非*failoverGroup结构")
+    }
+    g.stopOnce.Do(func() { close(g.stop) })
+    return nil
+}
+
+// 检查虚拟db的成员, 成员必须存在, 不能是虚拟db, 并且是同一种db类型, 返回成员的db类型. 调用者需要持有锁
+func (m *DBFactory) checkMembers(members []string) (DBType, error) {
+    var dbtype DBType
+    for _, member := range members {
+        conf, ok := m.confs[member]
+        if !ok {
+            return "", zerrors.NewSimplef("成员<%s>不存在", member)
+        }
+        if _, ok := conf.config.(iVirtualConfig); ok {
+            return "", zerrors.NewSimplef("成员<%s>不能是虚拟db", member)
+        }
+        if dbtype == "" {
+            dbtype = conf.dbtype
+        } else if conf.dbtype != dbtype {
+            return "", zerrors.NewSimplef("成员<%s>的db类型<%s>与其它成员的<%s>不同", member, conf.dbtype, dbtype)
+        }
+    }
+    return dbtype, nil
+}
+
+// 故障转移组实例
+type failoverGroup struct {
+    dbname    string
+    factory   *DBFactory
+    members   []string
+    interval  time.Duration
+    timeout   time.Duration
+    threshold int
+    sticky    bool
+
+    failures map[string]int // 每个成员连续失败的次数, 只在monitor中访问
+    active   string
+    mx       sync.RWMutex
+    stop     chan struct{}
+    stopOnce sync.Once
+}
+
+func (g *failoverGroup) activeMember() string {
+    g.mx.RLock()
+    defer g.mx.RUnlock()
+    return g.active
+}
+
+// 定期检查成员的健康状态并选择成员
+func (g *failoverGroup) monitor() {
+    ticker := time.NewTicker(g.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-g.stop:
+            return
+        case <-ticker.C:
+            g.check()
+        }
+    }
+}
+
+// 检查一次所有成员, 并根据策略切换成员
+func (g *failoverGroup) check() {
+    healthy := make(map[string]bool, len(g.members))
+    for _, member := range g.members {
+        if g.ping(member) {
+            g.failures[member] = 0
+        } else {
+            g.failures[member]++
+        }
+        healthy[member] = g.failures[member] < g.threshold
+    }
+
+    current := g.activeMember()
+    next := current
+    if !g.sticky || !healthy[current] {
+        next = ""
+        for _, member := range g.members {
+            if healthy[member] {
+                next = member
+                break
+            }
+        }
+    }
+
+    log := g.factory.log
+    if next == "" {
+        log.Error("failover没有健康的成员, 继续使用当前成员", F("dbname", g.dbname), F("active", current))
+        return
+    }
+    if next == current {
+        return
+    }
+
+    g.mx.Lock()
+    g.active = next
+    g.mx.Unlock()
+    log.Warn("failover已切换成员", F("dbname", g.dbname), F("from", current), F("to", next))
+}
+
+// ping一个成员, 未连接时视为失败, 不支持ping时视为成功
+func (g *failoverGroup) ping(member string) bool {
+    ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+    defer cancel()
+    _, err := g.factory.Ping(ctx, member)
+    return err == nil || err == ErrPingNotSupported
+}
+
+// 将dbname转为小写并去掉空白和空值
+func lowerNames(names []string) []string {
+    out := make([]string, 0, len(names))
+    for _, name := range names {
+        if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+            out = append(out, name)
+        }
+    }
+    return out
+}
@@ -0,0 +1,170 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/18
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+)
+
+// 三个fake成员和一个failover组, 组的健康检查只在测试中手动触发
+func newFailoverFactory(failback string, fail ...string) *DBFactory {
+    m := New()
+    for _, member := range []string{"s1", "s2", "s3"} {
+        m.AddDBConfig(member, fakeDB, &fakeConfig{Fail: containsString(fail, member)})
+    }
+    m.AddDBConfig("other", fakeDB, &fakeConfig{})
+    m.AddDBConfig("group", Failover, &FailoverConfig{
+        Members:          []string{"S1", "s2", "s3"},
+        CheckInterval:    3600000,
+        FailureThreshold: 1,
+        Failback:         failback,
+    })
+    return m
+}
+
+func storedDBs(m *DBFactory) []string {
+    m.mx.RLock()
+    defer m.mx.RUnlock()
+    out := make([]string, 0, len(m.storage))
+    for dbname := range m.storage {
+        out = append(out, dbname)
+    }
+    sort.Strings(out)
+    return out
+}
+
+func TestConnectFailover(t *testing.T) {
+    tests := []struct {
+        name       string
+        fail       []string // 连接失败的成员
+        connect    func(m *DBFactory) error
+        wantErr    string // 错误信息中应包含的内容, 为空时不应返回错误
+        wantStored []string
+        wantActive string
+    }{
+        {
+            name:       "ConnectAllDB",
+            connect:    func(m *DBFactory) error { return m.ConnectAllDB() },
+            wantStored: []string{"group", "other", "s1", "s2", "s3"},
+            wantActive: "s1",
+        },
+        {
+            name:       "ConnectDBContext connects only the members",
+            connect:    func(m *DBFactory) error { return m.ConnectDBContext(context.Background(), "GROUP") },
+            wantStored: []string{"group", "s1", "s2", "s3"},
+            wantActive: "s1",
+        },
+        {
+            name:       "ConnectDBContext of a member",
+            connect:    func(m *DBFactory) error { return m.ConnectDBContext(context.Background(), "s2") },
+            wantStored: []string{"s2"},
+        },
+        {
+            name:    "ConnectDBContext of a missing db",
+            connect: func(m *DBFactory) error { return m.ConnectDBContext(context.Background(), "nope") },
+            wantErr: "不存在的dbname<nope>",
+        },
+        {
+            name:       "member connect failure",
+            fail:       []string{"s1"},
+            connect:    func(m *DBFactory) error { return m.ConnectDBContext(context.Background(), "group") },
+            wantErr:    "s1",
+            wantStored: []string{},
+        },
+    }
+    for _, tt := range tests {
+        m := newFailoverFactory("", tt.fail...)
+        err := tt.connect(m)
+        switch {
+        case tt.wantErr == "" && err != nil:
+            t.Errorf("%s: err = %v, want nil", tt.name, err)
+        case tt.wantErr != "" && err == nil:
+            t.Errorf("%s: err = nil, want %q", tt.name, tt.wantErr)
+        case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+            t.Errorf("%s: err = %q, want it to contain %q", tt.name, err, tt.wantErr)
+        }
+
+        if got := storedDBs(m); tt.wantStored != nil && !reflect.DeepEqual(got, tt.wantStored) {
+            t.Errorf("%s: connected = %v, want %v", tt.name, got, tt.wantStored)
+        }
+        if tt.wantActive != "" {
+            if got := m.GetDBInstance("group"); got != m.GetDBInstance(tt.wantActive) {
+                t.Errorf("%s: GetDBInstance(group) is not %s", tt.name, tt.wantActive)
+            }
+        }
+        m.CloseAllDb()
+    }
+}
+
+func TestFailoverSwitchover(t *testing.T) {
+    type step struct {
+        down       []string // 这次检查时不可用的成员
+        wantActive string
+    }
+    tests := []struct {
+        name     string
+        failback string
+        steps    []step
+    }{
+        {
+            name:     "preferred fails back",
+            failback: FailbackPreferred,
+            steps: []step{
+                {wantActive: "s1"},
+                {down: []string{"s1"}, wantActive: "s2"},
+                {down: []string{"s1", "s2"}, wantActive: "s3"},
+                {down: []string{"s1"}, wantActive: "s2"},
+                {wantActive: "s1"},
+            },
+        },
+        {
+            name:     "sticky keeps the current member",
+            failback: FailbackSticky,
+            steps: []step{
+                {down: []string{"s1"}, wantActive: "s2"},
+                {wantActive: "s2"},
+                {down: []string{"s2"}, wantActive: "s1"},
+            },
+        },
+        {
+            name:     "no healthy member keeps the current member",
+            failback: FailbackPreferred,
+            steps: []step{
+                {down: []string{"s1"}, wantActive: "s2"},
+                {down: []string{"s1", "s2", "s3"}, wantActive: "s2"},
+            },
+        },
+    }
+    for _, tt := range tests {
+        m := newFailoverFactory(tt.failback)
+        if err := m.ConnectAllDB(); err != nil {
+            t.Fatalf("%s: ConnectAllDB: %v", tt.name, err)
+        }
+        g := m.storage["group"].instance.(*failoverGroup)
+
+        for i, st := range tt.steps {
+            for _, member := range []string{"s1", "s2", "s3"} {
+                fakeConnOf(t, m, member).setDown(containsString(st.down, member))
+            }
+            g.check()
+
+            if got := g.activeMember(); got != st.wantActive {
+                t.Errorf("%s: step %d active = %s, want %s", tt.name, i, got, st.wantActive)
+            }
+            if m.GetDBInstance("group") != m.GetDBInstance(st.wantActive) {
+                t.Errorf("%s: step %d GetDBInstance(group) is not %s", tt.name, i, st.wantActive)
+            }
+        }
+        m.CloseAllDb()
+    }
+}
@@ -0,0 +1,130 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/3
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+
+    "github.com/zlyuancn/zerrors"
+    "gopkg.in/yaml.v2"
+)
+
+// 添加json文件, 重复的db名会被替换掉
+func (m *DBFactory) AddJSONFile(file string) error {
+    return m.addFile(file, "json", decodeJSON)
+}
+
+// 从reader中读取json并添加, 重复的db名会被替换掉
+func (m *DBFactory) AddJSONReader(r io.Reader) error {
+    return m.addReader(r, "json", decodeJSON)
+}
+
+// 添加json分片, 重复的db名会被替换掉
+func (m *DBFactory) AddJSONShard(dbname string, data []byte) error {
+    return m.addShardData(dbname, data, "json", decodeJSON)
+}
+
+// 添加yaml文件, 重复的db名会被替换掉
+func (m *DBFactory) AddYAMLFile(file string) error {
+    return m.addFile(file, "yaml", decodeYAML)
+}
+
+// 从reader中读取yaml并添加, 重复的db名会被替换掉
+func (m *DBFactory) AddYAMLReader(r io.Reader) error {
+    return m.addReader(r, "yaml", decodeYAML)
+}
+
+// 添加yaml分片, 重复的db名会被替换掉
+func (m *DBFactory) AddYAMLShard(dbname string, data []byte) error {
+    return m.addShardData(dbname, data, "yaml", decodeYAML)
+}
+
+func (m *DBFactory) addFile(file, format string, decode func([]byte) (map[string]interface{}, error)) error {
+    f, err := os.Open(file)
+    if err != nil {
+        return zerrors.WrapSimplef(err, "%s文件加载失败", format)
+    }
+    defer f.Close()
+
+    m.log.Info("加载配置文件", F("file", file))
+    return m.addReader(f, format, decode)
+}
+
+func (m *DBFactory) addReader(r io.Reader, format string, decode func([]byte) (map[string]interface{}, error)) error {
+    data, err := ioutil.ReadAll(r)
+    if err != nil {
+        return zerrors.WrapSimplef(err, "%s读取失败", format)
+    }
+    settings, err := decode(data)
+    if err != nil {
+        return zerrors.WrapSimplef(err, "%s解析失败", format)
+    }
+
+    shards, err := m.findShards(settings)
+    if err != nil {
+        return err
+    }
+    return m.addShards(shards)
+}
+
+func (m *DBFactory) addShardData(dbname string, data []byte, format string, decode func([]byte) (map[string]interface{}, error)) error {
+    shard, err := decode(data)
+    if err != nil {
+        return zerrors.WrapSimplef(err, "<%s>的%s解析失败", dbname, format)
+    }
+    return m.addShard(dbname, shard)
+}
+
+func decodeJSON(data []byte) (map[string]interface{}, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    var out map[string]interface{}
+    if err := decoder.Decode(&out); err != nil {
+        return nil, err
+    }
+    return plainValue(out).(map[string]interface{}), nil
+}
+
+func decodeYAML(data []byte) (map[string]interface{}, error) {
+    var out map[string]interface{}
+    if err := yaml.Unmarshal(data, &out); err != nil {
+        return nil, err
+    }
+    return yamlValue(out).(map[string]interface{}), nil
+}
+
+// 将yaml解码出的 map[interface{}]interface{} 转为 map[string]interface{}
+func yamlValue(v interface{}) interface{} {
+    switch vv := v.(type) {
+    case map[interface{}]interface{}:
+        out := make(map[string]interface{}, len(vv))
+        for k, item := range vv {
+            out[fmt.Sprint(k)] = yamlValue(item)
+        }
+        return out
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(vv))
+        for k, item := range vv {
+            out[k] = yamlValue(item)
+        }
+        return out
+    case []interface{}:
+        out := make([]interface{}, len(vv))
+        for i, item := range vv {
+            out[i] = yamlValue(item)
+        }
+        return out
+    }
+    return v
+}
@@ -0,0 +1,162 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/20
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "runtime"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// 默认的关闭实例前等待句柄释放的最长时间
+const DefaultReleaseTimeout = time.Second * 30
+
+// db实例的句柄, 持有句柄期间RemoveDB和替换配置会立即返回, 实例在句柄全部释放后才会在后台关闭
+//
+// 使用完后必须调用Release, 没有释放就被回收的句柄会被记录为泄漏
+type DBHandle struct {
+    *DBInstance
+    dbname   string
+    factory  *DBFactory
+    released int32
+}
+
+// 释放句柄, 可以重复调用
+func (h *DBHandle) Release() {
+    if !atomic.CompareAndSwapInt32(&h.released, 0, 1) {
+        return
+    }
+    runtime.SetFinalizer(h, nil)
+    h.release()
+}
+
+func (h *DBHandle) release() {
+    h.DBInstance.refs.release()
+    h.factory.metrics.observeHandle(h.dbname, h.dbtype, false)
+}
+
+// 句柄被回收时仍未释放
+func (h *DBHandle) leaked() {
+    if !atomic.CompareAndSwapInt32(&h.released, 0, 1) {
+        return
+    }
+    h.factory.log.Warn("db句柄没有释放", F("dbname", h.dbname), F("dbtype", h.dbtype))
+    h.factory.metrics.observeLeak(h.dbname, h.dbtype)
+    h.release()
+}
+
+// 获取db实例的句柄, 使用完后必须调用Release
+//
+// 虚拟db(如failover)会返回它当前使用的成员的句柄
+func (m *DBFactory) Acquire(dbname string) (*DBHandle, error) {
+    dbname = strings.ToLower(dbname)
+
+    m.mx.RLock()
+    instance := m.storage[dbname]
+    if instance != nil {
+        if v, ok := instance.instance.(iVirtualInstance); ok {
+            dbname = v.activeMember()
+            instance = m.storage[dbname]
+        }
+    }
+    if instance != nil {
+        instance.refs.acquire()
+    }
+    m.mx.RUnlock()
+
+    if instance == nil {
+        return nil, m.instanceNotFoundError(dbname)
+    }
+
+    m.metrics.observeHandle(dbname, instance.dbtype, true)
+    h := &DBHandle{DBInstance: instance, dbname: dbname, factory: m}
+    runtime.SetFinalizer(h, (*DBHandle).leaked)
+    return h, nil
+}
+
+// 将dbname的实例从storage中移除, 等待它的句柄全部释放后关闭, 最多等待到deadline
+//
+// 调用者需要持有锁, 等待和关闭期间会释放锁
+func (m *DBFactory) closeWhenReleased(dbname string, deadline time.Time) {
+    for {
+        instance, ok := m.storage[dbname]
+        if !ok {
+            return
+        }
+        delete(m.storage, dbname)
+
+        m.mx.Unlock()
+        m.waitReleased(dbname, instance, deadline)
+        _ = m.closeDB(dbname, instance)
+        m.mx.Lock()
+    }
+}
+
+// 等待实例的句柄全部释放, 最多等待到deadline
+func (m *DBFactory) waitReleased(dbname string, instance *DBInstance, deadline time.Time) {
+    if n := instance.refs.wait(time.Until(deadline)); n > 0 {
+        m.log.Warn("等待db句柄释放超时, 实例将被关闭", F("dbname", dbname), F("dbtype", instance.dbtype), F("handles", n))
+        m.metrics.observeReleaseTimeout(dbname, instance.dbtype)
+    }
+}
+
+// 实例的句柄计数
+type refCounter struct {
+    n       int
+    drained chan struct{} // 有等待者时创建, 计数归零时关闭
+    mx      sync.Mutex
+}
+
+func (r *refCounter) acquire() {
+    r.mx.Lock()
+    r.n++
+    r.mx.Unlock()
+}
+
+func (r *refCounter) release() {
+    r.mx.Lock()
+    r.n--
+    if r.n == 0 && r.drained != nil {
+        close(r.drained)
+        r.drained = nil
+    }
+    r.mx.Unlock()
+}
+
+func (r *refCounter) count() int {
+    r.mx.Lock()
+    defer r.mx.Unlock()
+    return r.n
+}
+
+// 等待计数归零, 超时后返回剩余的计数, timeout不大于0时不会等待
+func (r *refCounter) wait(timeout time.Duration) int {
+    r.mx.Lock()
+    if r.n == 0 || timeout <= 0 {
+        n := r.n
+        r.mx.Unlock()
+        return n
+    }
+    if r.drained == nil {
+        r.drained = make(chan struct{})
+    }
+    drained := r.drained
+    r.mx.Unlock()
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    select {
+    case <-drained:
+        return 0
+    case <-timer.C:
+        return r.count()
+    }
+}
@@ -0,0 +1,225 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/20
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 测试用的db类型
+const fakeDB DBType = "fake"
+
+func init() {
+    RegistryDBFactory(fakeDB, fakeFactory{})
+}
+
+type fakeConfig struct {
+    Password   string
+    Fail       bool          // 连接失败
+    CloseDelay time.Duration // 关闭耗时
+}
+
+type fakeConn struct {
+    conf    *fakeConfig
+    closing chan struct{} // 开始关闭时关闭
+    closed  int32
+    down    int32 // ping失败
+    once    sync.Once
+}
+
+func (c *fakeConn) isClosed() bool {
+    return atomic.LoadInt32(&c.closed) == 1
+}
+
+func (c *fakeConn) setDown(down bool) {
+    var v int32
+    if down {
+        v = 1
+    }
+    atomic.StoreInt32(&c.down, v)
+}
+
+type fakeFactory struct{}
+
+var _ IDBFactory = (*fakeFactory)(nil)
+var _ IDBPinger = (*fakeFactory)(nil)
+
+func (fakeFactory) MakeEmptyConfig() interface{} {
+    return new(fakeConfig)
+}
+
+func (fakeFactory) Connect(config interface{}) (interface{}, error) {
+    conf := config.(*fakeConfig)
+    if conf.Fail {
+        return nil, zerrors.NewSimple("fake连接失败")
+    }
+    return &fakeConn{conf: conf, closing: make(chan struct{})}, nil
+}
+
+func (fakeFactory) Close(dbinstance interface{}) error {
+    c := dbinstance.(*fakeConn)
+    c.once.Do(func() { close(c.closing) })
+    time.Sleep(c.conf.CloseDelay)
+    atomic.StoreInt32(&c.closed, 1)
+    return nil
+}
+
+func (fakeFactory) Ping(ctx context.Context, dbinstance interface{}) error {
+    if atomic.LoadInt32(&dbinstance.(*fakeConn).down) == 1 {
+        return zerrors.NewSimple("fake不可用")
+    }
+    return nil
+}
+
+// 获取db当前的fake实例
+func fakeConnOf(t *testing.T, m *DBFactory, dbname string) *fakeConn {
+    t.Helper()
+    instance := m.GetDBInstance(dbname)
+    if instance == nil {
+        t.Fatalf("db<%s> is not connected", dbname)
+    }
+    return instance.instance.(*fakeConn)
+}
+
+// 在timeout内等待cond成立
+func waitFor(timeout time.Duration, cond func() bool) bool {
+    deadline := time.Now().Add(timeout)
+    for !cond() {
+        if time.Now().After(deadline) {
+            return false
+        }
+        time.Sleep(time.Millisecond * 5)
+    }
+    return true
+}
+
+func TestHandleRefCount(t *testing.T) {
+    tests := []struct {
+        name    string
+        handles int
+        release int // 移除db后释放的句柄数
+        closed  bool
+    }{
+        {name: "no handles", handles: 0, release: 0, closed: true},
+        {name: "all released", handles: 2, release: 2, closed: true},
+        {name: "one still held", handles: 2, release: 1, closed: false},
+    }
+    for _, tt := range tests {
+        m := New()
+        m.AddDBConfig("a", fakeDB, &fakeConfig{})
+        if err := m.ConnectAllDB(); err != nil {
+            t.Fatalf("%s: ConnectAllDB: %v", tt.name, err)
+        }
+        conn := fakeConnOf(t, m, "a")
+
+        handles := make([]*DBHandle, tt.handles)
+        for i := range handles {
+            h, err := m.Acquire("a")
+            if err != nil {
+                t.Fatalf("%s: Acquire: %v", tt.name, err)
+            }
+            handles[i] = h
+        }
+        if n := m.GetDBInstance("a").refs.count(); n != tt.handles {
+            t.Errorf("%s: refs = %d, want %d", tt.name, n, tt.handles)
+        }
+
+        m.RemoveDB("a")
+        for _, h := range handles[:tt.release] {
+            h.Release()
+            h.Release() // 重复释放不会影响计数
+        }
+
+        if got := waitFor(time.Millisecond*200, conn.isClosed); got != tt.closed {
+            t.Errorf("%s: closed = %v, want %v", tt.name, got, tt.closed)
+        }
+        for _, h := range handles[tt.release:] {
+            h.Release()
+        }
+        if !waitFor(time.Second, conn.isClosed) {
+            t.Errorf("%s: instance is not closed after all handles are released", tt.name)
+        }
+    }
+}
+
+func TestCloseLaterReleaseTimeout(t *testing.T) {
+    m := New()
+    m.releaseTimeout = time.Millisecond * 50
+    m.AddDBConfig("a", fakeDB, &fakeConfig{})
+    if err := m.ConnectAllDB(); err != nil {
+        t.Fatal(err)
+    }
+    conn := fakeConnOf(t, m, "a")
+
+    h, err := m.Acquire("a")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer h.Release()
+
+    m.RemoveDB("a")
+    if !waitFor(time.Second, conn.isClosed) {
+        t.Error("instance is not closed after releaseTimeout")
+    }
+}
+
+func TestSlowCloseDoesNotBlockFactory(t *testing.T) {
+    const closeDelay = time.Millisecond * 500
+
+    tests := []struct {
+        name   string
+        detach func(m *DBFactory) // 使a的实例在后台关闭
+    }{
+        {name: "RemoveDB", detach: func(m *DBFactory) { m.RemoveDB("a") }},
+        {name: "AddDBConfig", detach: func(m *DBFactory) { m.AddDBConfig("a", fakeDB, &fakeConfig{}) }},
+        {name: "Reconnect", detach: func(m *DBFactory) {
+            m.drainTime = 0
+            if err := m.Reconnect("a"); err != nil {
+                t.Fatal(err)
+            }
+        }},
+    }
+    for _, tt := range tests {
+        m := New()
+        m.AddDBConfig("a", fakeDB, &fakeConfig{CloseDelay: closeDelay})
+        m.AddDBConfig("b", fakeDB, &fakeConfig{})
+        if err := m.ConnectAllDB(); err != nil {
+            t.Fatalf("%s: ConnectAllDB: %v", tt.name, err)
+        }
+        conn := fakeConnOf(t, m, "a")
+
+        tt.detach(m)
+        select {
+        case <-conn.closing:
+        case <-time.After(time.Second):
+            t.Fatalf("%s: instance is not being closed", tt.name)
+        }
+
+        // a正在关闭时其它db的操作不会被阻塞
+        start := time.Now()
+        if m.GetDBInstance("b") == nil {
+            t.Errorf("%s: GetDBInstance(b) = nil", tt.name)
+        }
+        m.AddDBConfig("c", fakeDB, &fakeConfig{})
+        if elapsed := time.Since(start); elapsed > closeDelay/2 {
+            t.Errorf("%s: factory was blocked for %v while closing a", tt.name, elapsed)
+        }
+
+        if !waitFor(time.Second, conn.isClosed) {
+            t.Errorf("%s: instance is not closed", tt.name)
+        }
+        m.CloseAllDb()
+    }
+}
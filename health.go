@@ -0,0 +1,86 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/29
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "strings"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// db类型的factory没有实现IDBPinger时Ping返回的错误
+var ErrPingNotSupported = zerrors.NewSimple("db类型不支持ping")
+
+// ping一个已连接的db, 返回耗时
+//
+// db类型的factory没有实现IDBPinger时返回ErrPingNotSupported
+func (m *DBFactory) Ping(ctx context.Context, dbname string) (time.Duration, error) {
+    instance := m.GetDBInstance(dbname)
+    if instance == nil {
+        return 0, zerrors.NewSimplef("<%s>未连接", dbname)
+    }
+
+    pinger, ok := m.mustGetFactory(instance.dbtype).(IDBPinger)
+    if !ok {
+        return 0, ErrPingNotSupported
+    }
+
+    start := time.Now()
+    err := pinger.Ping(ctx, instance.instance)
+    return time.Since(start), err
+}
+
+// 重新连接db, 未连接的db会直接连接
+//
+// 新实例连接成功后才会替换旧实例, 旧实例会在等待一段时间后关闭. 连接失败时保留旧实例并返回错误
+//
+// 连接新实例时不持有工厂的锁, 慢连接不会阻塞其它db
+func (m *DBFactory) Reconnect(dbname string) error {
+    dbname = strings.ToLower(dbname)
+
+    m.mx.Lock()
+    conf, ok := m.confs[dbname]
+    if !ok {
+        m.mx.Unlock()
+        return zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+    }
+
+    // 连接时会释放锁, 期间配置被修改时返回错误
+    instance, err := m.connectDB(dbname, conf, conf)
+    if err != nil {
+        m.mx.Unlock()
+        return zerrors.WrapSimplef(err, "<%s>重连失败", dbname)
+    }
+
+    oldInstance, connected := m.storage[dbname]
+    m.storage[dbname] = newDBInstance(conf, instance)
+    m.mx.Unlock()
+
+    if connected {
+        m.closeLater(dbname, oldInstance, m.drainTime)
+    }
+    return nil
+}
+
+// 在ctx结束前等待fn完成, 用于不支持context的客户端
+func pingWithContext(ctx context.Context, fn func() error) error {
+    done := make(chan error, 1)
+    go func() {
+        done <- fn()
+    }()
+
+    select {
+    case err := <-done:
+        return err
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
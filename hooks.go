@@ -0,0 +1,121 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/20
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "reflect"
+
+    "github.com/Shopify/sarama"
+    "github.com/go-redis/redis"
+    "github.com/zlyuancn/zerrors"
+)
+
+// 钩子事件
+type HookEvent int
+
+const (
+    // 连接之前, 可以修改或替换 HookContext.Config, 返回错误会放弃连接
+    BeforeConnect HookEvent = iota
+    // 连接之后, 可以替换 HookContext.Instance, 返回错误会关闭 HookContext.Instance 并视为连接失败
+    //
+    // 替换的实例必须与原实例的类型一致, redis只需要实现redis.UniversalClient, kafka生产者只需要实现原实例的生产者接口
+    AfterConnect
+    // 关闭之前
+    BeforeClose
+    // 关闭之后, HookContext.Err 为关闭时的错误
+    AfterClose
+    // 添加了新的db配置
+    ConfigAdded
+    // 替换了已存在的db配置
+    ConfigReplaced
+    // 移除了db配置
+    ConfigRemoved
+    // ConnectAllDB连接失败后重试之前, HookContext.Err 为上一次的错误, 返回错误会停止重试
+    ConnectRetry
+)
+
+var hookEventNames = map[HookEvent]string{
+    BeforeConnect:  "BeforeConnect",
+    AfterConnect:   "AfterConnect",
+    BeforeClose:    "BeforeClose",
+    AfterClose:     "AfterClose",
+    ConfigAdded:    "ConfigAdded",
+    ConfigReplaced: "ConfigReplaced",
+    ConfigRemoved:  "ConfigRemoved",
+    ConnectRetry:   "ConnectRetry",
+}
+
+func (e HookEvent) String() string {
+    if name, ok := hookEventNames[e]; ok {
+        return name
+    }
+    return "Unknown"
+}
+
+// 钩子参数
+type HookContext struct {
+    Event    HookEvent
+    DBName   string
+    DBType   DBType
+    Config   interface{}
+    Instance interface{} // 连接之前和配置事件中为nil
+    Err      error
+    Attempt  int // ConnectRetry中为即将进行的是第几次尝试, 从2开始
+}
+
+// 钩子函数
+//
+// 钩子在工厂的锁外执行, 可以在钩子中调用工厂的方法
+// 只有 BeforeConnect, AfterConnect 和 ConnectRetry 的返回值会生效, 其它事件返回的错误只会被记录到日志
+type HookFunc func(ctx *HookContext) error
+
+// 注册钩子
+func (m *DBFactory) AddHook(event HookEvent, fn HookFunc) {
+    m.hookMx.Lock()
+    m.hooks[event] = append(m.hooks[event], fn)
+    m.hookMx.Unlock()
+}
+
+// 触发钩子, 遇到错误时停止, 不需要持有工厂的锁
+func (m *DBFactory) triggerHook(ctx *HookContext) error {
+    m.hookMx.RLock()
+    hooks := m.hooks[ctx.Event]
+    m.hookMx.RUnlock()
+
+    for _, fn := range hooks {
+        if err := fn(ctx); err != nil {
+            m.log.Warn("钩子返回错误", F("event", ctx.Event.String()), F("dbname", ctx.DBName), F("dbtype", ctx.DBType), F("error", err.Error()))
+            return err
+        }
+    }
+    return nil
+}
+
+// 检查AfterConnect钩子替换的实例, 获取实例的函数(如GetRedis)会直接断言实例的类型
+func checkReplacedInstance(original, replaced interface{}) error {
+    if replaced == nil {
+        return zerrors.NewSimple("AfterConnect钩子将实例替换为nil")
+    }
+
+    var ok bool
+    switch original.(type) {
+    case redis.UniversalClient:
+        _, ok = replaced.(redis.UniversalClient)
+    case sarama.SyncProducer:
+        _, ok = replaced.(sarama.SyncProducer)
+    case sarama.AsyncProducer:
+        _, ok = replaced.(sarama.AsyncProducer)
+    default:
+        ok = reflect.TypeOf(replaced) == reflect.TypeOf(original)
+    }
+    if !ok {
+        return zerrors.NewSimplef("AfterConnect钩子替换的实例类型<%T>与原实例类型<%T>不一致", replaced, original)
+    }
+    return nil
+}
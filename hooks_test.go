@@ -0,0 +1,97 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/20
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "testing"
+    "time"
+)
+
+func TestHookCanCallFactory(t *testing.T) {
+    tests := []struct {
+        event HookEvent
+        run   func(m *DBFactory)
+    }{
+        {event: ConfigAdded, run: func(m *DBFactory) { m.AddDBConfig("b", fakeDB, &fakeConfig{}) }},
+        {event: ConfigReplaced, run: func(m *DBFactory) { m.AddDBConfig("a", fakeDB, &fakeConfig{}) }},
+        {event: ConfigRemoved, run: func(m *DBFactory) { m.RemoveDB("a") }},
+        {event: BeforeConnect, run: func(m *DBFactory) { _ = m.Reconnect("a") }},
+        {event: AfterConnect, run: func(m *DBFactory) { _ = m.Reconnect("a") }},
+        {event: BeforeClose, run: func(m *DBFactory) { m.CloseAllDb() }},
+        {event: AfterClose, run: func(m *DBFactory) { m.CloseAllDb() }},
+        {event: ConnectRetry, run: func(m *DBFactory) {
+            m.AddDBConfig("b", fakeDB, &fakeConfig{Fail: true})
+            m.retryPolicy = &RetryPolicy{MaxAttempts: 2, InitialBackoff: 1}
+            _ = m.ConnectAllDB()
+        }},
+        {event: BeforeConnect, run: func(m *DBFactory) {
+            // 虚拟db的连接钩子也在锁外执行
+            m.AddDBConfig("group", Failover, &FailoverConfig{Members: []string{"a"}})
+            _ = m.ConnectAllDB()
+        }},
+        {event: AfterConnect, run: func(m *DBFactory) {
+            m.AddDBConfig("group", Failover, &FailoverConfig{Members: []string{"a"}})
+            _ = m.ConnectAllDB()
+        }},
+    }
+    for i, tt := range tests {
+        m := New()
+        m.AddDBConfig("a", fakeDB, &fakeConfig{})
+        if err := m.ConnectAllDB(); err != nil {
+            t.Fatalf("%d %s: ConnectAllDB: %v", i, tt.event, err)
+        }
+
+        called := make(chan struct{}, 16)
+        m.AddHook(tt.event, func(ctx *HookContext) error {
+            m.ListDBs()
+            m.AddDBConfig("from_hook", fakeDB, &fakeConfig{})
+            called <- struct{}{}
+            return nil
+        })
+
+        done := make(chan struct{})
+        go func() {
+            tt.run(m)
+            close(done)
+        }()
+        select {
+        case <-done:
+        case <-time.After(time.Second * 5):
+            t.Fatalf("%d %s: hook calling the factory deadlocked", i, tt.event)
+        }
+        if len(called) == 0 {
+            t.Errorf("%d %s: hook was not called", i, tt.event)
+        }
+        m.CloseAllDb()
+    }
+}
+
+func TestConnectRetryHookStops(t *testing.T) {
+    m := New()
+    m.retryPolicy = &RetryPolicy{MaxAttempts: 5, InitialBackoff: 1}
+    m.AddDBConfig("a", fakeDB, &fakeConfig{Fail: true})
+
+    attempts := 0
+    m.AddHook(ConnectRetry, func(ctx *HookContext) error {
+        attempts = ctx.Attempt
+        if ctx.Attempt >= 3 {
+            return ctx.Err
+        }
+        return nil
+    })
+    if err := m.ConnectAllDB(); err == nil {
+        t.Fatal("ConnectAllDB err = nil, want an error")
+    }
+    if attempts != 3 {
+        t.Errorf("last retry attempt = %d, want 3", attempts)
+    }
+    if m.GetDBInstance("a") != nil {
+        t.Error("failed db is stored")
+    }
+}
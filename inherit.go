@@ -0,0 +1,194 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/2
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "regexp"
+    "strconv"
+    "strings"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 分片中的保留字段, 它们在构建配置前会被去掉
+const (
+    // 继承另一个分片的所有字段, 值为被继承的dbname
+    ExtendsField = "extends"
+    // 为true时这个分片只用于被继承, 不会被添加
+    AbstractField = "abstract"
+    // 模板分片展开后的dbname列表, 可以是数组或 "orders_{0..15}" 形式的范围
+    NamesField = "names"
+)
+
+// 模板中的占位符, ${index}为在names中的序号, ${name}为展开后的dbname
+const (
+    templateIndex = "${index}"
+    templateName  = "${name}"
+)
+
+// names中的范围, 如 orders_{0..15}
+var namesRangeRegexp = regexp.MustCompile(`^(.*)\{(\d+)\.\.(\d+)\}(.*)$`)
+
+// 处理分片的继承和模板
+//
+// 先解析extends, 再去掉abstract分片, 最后将带有names的模板分片展开为多个分片
+// 被继承的分片可以是本次加载的分片, 也可以是之前从配置分片中加载的db
+func (m *DBFactory) expandShards(shards map[string]map[string]interface{}) (map[string]map[string]interface{}, error) {
+    var errs ShardErrors
+    resolved := make(map[string]map[string]interface{}, len(shards))
+    for dbname := range shards {
+        shard, err := m.resolveExtends(shards, resolved, dbname, nil)
+        if err != nil {
+            errs = append(errs, err)
+        }
+        resolved[dbname] = shard
+    }
+    if len(errs) > 0 {
+        return nil, errs
+    }
+
+    out := make(map[string]map[string]interface{}, len(resolved))
+    for dbname, shard := range resolved {
+        if abstract, _ := shardField(shard, AbstractField); abstract == true {
+            continue
+        }
+
+        rawNames, ok := shardField(shard, NamesField)
+        if !ok {
+            if _, dup := out[dbname]; dup {
+                errs = append(errs, zerrors.NewSimplef("重复的dbname<%s>", dbname))
+            }
+            out[dbname] = dropReservedFields(shard)
+            continue
+        }
+
+        names, err := parseTemplateNames(rawNames)
+        if err != nil {
+            errs = append(errs, zerrors.WrapSimplef(err, "<%s>的%s错误", dbname, NamesField))
+            continue
+        }
+        for i, name := range names {
+            name = strings.ToLower(name)
+            if _, dup := out[name]; dup {
+                errs = append(errs, zerrors.NewSimplef("模板<%s>展开的dbname<%s>重复", dbname, name))
+                continue
+            }
+            out[name] = expandTemplate(dropReservedFields(shard), i, name)
+        }
+    }
+    if len(errs) > 0 {
+        return nil, errs
+    }
+    return out, nil
+}
+
+// 解析分片的extends, chain为正在解析的dbname, 用于检测循环继承
+func (m *DBFactory) resolveExtends(shards, resolved map[string]map[string]interface{}, dbname string, chain []string) (map[string]interface{}, error) {
+    if shard, ok := resolved[dbname]; ok {
+        return shard, nil
+    }
+    if containsString(chain, dbname) {
+        return nil, zerrors.NewSimplef("循环继承: %s -> %s", strings.Join(chain, " -> "), dbname)
+    }
+
+    shard, ok := shards[dbname]
+    if !ok {
+        m.mx.RLock()
+        conf, ok := m.confs[dbname]
+        m.mx.RUnlock()
+        if !ok || conf.raw == nil {
+            return nil, zerrors.NewSimplef("<%s>继承了不存在的分片<%s>", chain[len(chain)-1], dbname)
+        }
+        return conf.raw, nil
+    }
+
+    v, ok := shardField(shard, ExtendsField)
+    if !ok {
+        return shard, nil
+    }
+    base, ok := v.(string)
+    if !ok || base == "" {
+        return nil, zerrors.NewSimplef("<%s>的%s必须为非空字符串", dbname, ExtendsField)
+    }
+
+    baseShard, err := m.resolveExtends(shards, resolved, strings.ToLower(base), append(chain, dbname))
+    if err != nil {
+        return nil, err
+    }
+
+    // abstract和names只对声明它们的分片生效, 不会被继承
+    baseShard = dropFields(baseShard, AbstractField, NamesField)
+    return dropFields(mergeShard(baseShard, shard), ExtendsField), nil
+}
+
+// 解析模板的names
+func parseTemplateNames(v interface{}) ([]string, error) {
+    switch vv := v.(type) {
+    case string:
+        sub := namesRangeRegexp.FindStringSubmatch(vv)
+        if sub == nil {
+            return []string{vv}, nil
+        }
+        from, _ := strconv.Atoi(sub[2])
+        to, _ := strconv.Atoi(sub[3])
+        if from > to {
+            return nil, zerrors.NewSimplef("范围<%s>的起始值大于结束值", vv)
+        }
+        names := make([]string, 0, to-from+1)
+        for i := from; i <= to; i++ {
+            names = append(names, sub[1]+strconv.Itoa(i)+sub[4])
+        }
+        return names, nil
+    case []interface{}:
+        names := make([]string, len(vv))
+        for i, item := range vv {
+            name, ok := item.(string)
+            if !ok || name == "" {
+                return nil, zerrors.NewSimple("必须为非空字符串数组")
+            }
+            names[i] = name
+        }
+        return names, nil
+    case []string:
+        return vv, nil
+    }
+    return nil, zerrors.NewSimple("必须为字符串或字符串数组")
+}
+
+// 将模板中的占位符替换为序号和dbname
+func expandTemplate(shard map[string]interface{}, index int, name string) map[string]interface{} {
+    replacer := strings.NewReplacer(templateIndex, strconv.Itoa(index), templateName, name)
+    out, _, _ := walkStrings(shard, func(s string) (string, bool, error) {
+        return replacer.Replace(s), false, nil
+    })
+    return out.(map[string]interface{})
+}
+
+// 去掉分片中的保留字段
+func dropReservedFields(shard map[string]interface{}) map[string]interface{} {
+    return dropFields(shard, ExtendsField, AbstractField, NamesField)
+}
+
+// 返回去掉了指定字段的分片, key不区分大小写
+func dropFields(shard map[string]interface{}, keys ...string) map[string]interface{} {
+    out := make(map[string]interface{}, len(shard))
+    for k, v := range shard {
+        drop := false
+        for _, key := range keys {
+            if strings.EqualFold(k, key) {
+                drop = true
+                break
+            }
+        }
+        if !drop {
+            out[k] = v
+        }
+    }
+    return out
+}
@@ -0,0 +1,139 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/2
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "reflect"
+    "testing"
+)
+
+type testShards = map[string]map[string]interface{}
+
+func TestExpandShards(t *testing.T) {
+    tests := []struct {
+        name    string
+        loaded  testShards // 之前从配置分片中加载的db
+        in      testShards
+        want    testShards
+        wantErr bool
+    }{
+        {
+            name: "plain",
+            in:   testShards{"a": {"dbtype": "redis", "address": "h1"}},
+            want: testShards{"a": {"dbtype": "redis", "address": "h1"}},
+        },
+        {
+            name: "extends overrides base fields",
+            in: testShards{
+                "base":  {"dbtype": "redis", "address": "h1", "poolsize": 10},
+                "child": {"extends": "Base", "address": "h2"},
+            },
+            want: testShards{
+                "base":  {"dbtype": "redis", "address": "h1", "poolsize": 10},
+                "child": {"dbtype": "redis", "address": "h2", "poolsize": 10},
+            },
+        },
+        {
+            name: "extends chain and abstract base",
+            in: testShards{
+                "base":   {"abstract": true, "dbtype": "redis", "poolsize": 10},
+                "middle": {"extends": "base", "address": "h1"},
+                "leaf":   {"extends": "middle", "poolsize": 20},
+            },
+            want: testShards{
+                "middle": {"dbtype": "redis", "address": "h1", "poolsize": 10},
+                "leaf":   {"dbtype": "redis", "address": "h1", "poolsize": 20},
+            },
+        },
+        {
+            name:   "extends a loaded db",
+            loaded: testShards{"base": {"dbtype": "redis", "address": "h1"}},
+            in:     testShards{"child": {"extends": "base", "db": 2}},
+            want:   testShards{"child": {"dbtype": "redis", "address": "h1", "db": 2}},
+        },
+        {
+            name: "template range",
+            in: testShards{"orders": {
+                "names":   "orders_{0..2}",
+                "dbtype":  "mysql",
+                "dbname":  "orders_${index}",
+                "address": []interface{}{"${name}.db:3306"},
+            }},
+            want: testShards{
+                "orders_0": {"dbtype": "mysql", "dbname": "orders_0", "address": []interface{}{"orders_0.db:3306"}},
+                "orders_1": {"dbtype": "mysql", "dbname": "orders_1", "address": []interface{}{"orders_1.db:3306"}},
+                "orders_2": {"dbtype": "mysql", "dbname": "orders_2", "address": []interface{}{"orders_2.db:3306"}},
+            },
+        },
+        {
+            name: "template list inherits and names are not inherited",
+            in: testShards{
+                "base": {"names": []interface{}{"x", "y"}, "dbtype": "redis", "db": "${index}"},
+                "tpl":  {"extends": "base", "names": []interface{}{"A", "b"}},
+            },
+            want: testShards{
+                "x": {"dbtype": "redis", "db": "0"},
+                "y": {"dbtype": "redis", "db": "1"},
+                "a": {"dbtype": "redis", "db": "0"},
+                "b": {"dbtype": "redis", "db": "1"},
+            },
+        },
+        {
+            name: "cyclic extends",
+            in: testShards{
+                "a": {"extends": "b"},
+                "b": {"extends": "a"},
+            },
+            wantErr: true,
+        },
+        {
+            name:    "extends missing shard",
+            in:      testShards{"a": {"extends": "nope"}},
+            wantErr: true,
+        },
+        {
+            name:    "extends is not a string",
+            in:      testShards{"a": {"extends": 1}},
+            wantErr: true,
+        },
+        {
+            name: "template name conflicts with a shard",
+            in: testShards{
+                "s_1": {"dbtype": "redis"},
+                "tpl": {"names": "s_{0..1}", "dbtype": "redis"},
+            },
+            wantErr: true,
+        },
+        {
+            name:    "reversed range",
+            in:      testShards{"tpl": {"names": "s_{3..1}", "dbtype": "redis"}},
+            wantErr: true,
+        },
+        {
+            name:    "names is not a string list",
+            in:      testShards{"tpl": {"names": []interface{}{"a", 1}, "dbtype": "redis"}},
+            wantErr: true,
+        },
+    }
+    for _, tt := range tests {
+        m := New()
+        for dbname, raw := range tt.loaded {
+            m.confs[dbname] = &dbConfig{dbtype: DBType(raw["dbtype"].(string)), raw: raw}
+        }
+
+        got, err := m.expandShards(tt.in)
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+            continue
+        }
+        if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,267 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/16
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "net/http"
+    "sync"
+
+    "github.com/Shopify/sarama"
+    "github.com/go-redis/redis"
+    "github.com/jinzhu/gorm"
+    "go.mongodb.org/mongo-driver/event"
+)
+
+// gorm中保存调用者context的key, 见GormWithContext
+const GormContextKey = "zdb:context"
+
+// 为gorm的操作设置调用者的context, 链路追踪会使用它作为父context, 限流会在它结束时停止等待
+func GormWithContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+    return db.Set(GormContextKey, ctx)
+}
+
+// 获取gorm操作的context, 没有设置时返回context.Background()
+func gormContext(scope *gorm.Scope) context.Context {
+    if v, ok := scope.Get(GormContextKey); ok {
+        if ctx, ok := v.(context.Context); ok && ctx != nil {
+            return ctx
+        }
+    }
+    return context.Background()
+}
+
+// 连接信息, 在创建实例时传递给插件
+type connectInfo struct {
+    dbname string
+    dbtype DBType
+    config interface{}
+}
+
+// 实例插件, 工厂在创建实例时会用插件对实例进行装配
+//
+// 插件通过实现以下可选接口来支持不同类型的实例:
+//
+//    redisPlugin, redisLimiterPlugin, gormPlugin, httpPlugin, kafkaPlugin, mongoPlugin
+type instancePlugin interface{}
+
+// 为redis实例包装命令处理函数
+type redisPlugin interface {
+    wrapRedis(info *connectInfo, c redis.UniversalClient)
+}
+
+// 为redis实例提供限制器, 限制器失败时命令会直接返回错误
+type redisLimiterPlugin interface {
+    redisLimiter(info *connectInfo) redis.Limiter
+}
+
+// 为gorm实例注册回调
+type gormPlugin interface {
+    registerGorm(info *connectInfo, db *gorm.DB)
+}
+
+// 为使用http协议的实例(es)包装transport
+type httpPlugin interface {
+    wrapTransport(info *connectInfo, rt http.RoundTripper) http.RoundTripper
+}
+
+// 为kafka生产者实例进行包装
+type kafkaPlugin interface {
+    wrapSyncProducer(info *connectInfo, p sarama.SyncProducer) sarama.SyncProducer
+    wrapAsyncProducer(info *connectInfo, p sarama.AsyncProducer) sarama.AsyncProducer
+}
+
+// 为mongo实例提供命令监视器
+type mongoPlugin interface {
+    commandMonitor(info *connectInfo) *event.CommandMonitor
+}
+
+// 支持在连接时接收插件的factory
+//
+// 有些实例只能在创建时装配(如es的transport和mongo的命令监视器), 这类factory需要实现该接口
+type iDBFactoryWithPlugins interface {
+    connectWithPlugins(info *connectInfo, plugins []instancePlugin) (interface{}, error)
+}
+
+// 构建实例的http transport
+func pluginTransport(info *connectInfo, plugins []instancePlugin) http.RoundTripper {
+    var rt http.RoundTripper = http.DefaultTransport
+    wrapped := false
+    for _, p := range plugins {
+        if hp, ok := p.(httpPlugin); ok {
+            rt = hp.wrapTransport(info, rt)
+            wrapped = true
+        }
+    }
+    if !wrapped {
+        return nil
+    }
+    return rt
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+    return f(req)
+}
+
+// 构建redis的限制器, 多个插件的限制器会被合并
+func pluginRedisLimiter(info *connectInfo, plugins []instancePlugin) redis.Limiter {
+    var limiters multiRedisLimiter
+    for _, p := range plugins {
+        if lp, ok := p.(redisLimiterPlugin); ok {
+            limiters = append(limiters, lp.redisLimiter(info))
+        }
+    }
+
+    switch len(limiters) {
+    case 0:
+        return nil
+    case 1:
+        return limiters[0]
+    }
+    return limiters
+}
+
+// 按顺序调用多个限制器, 某个限制器拒绝时, 已放行的限制器会收到nil, 因为请求没有被执行
+type multiRedisLimiter []redis.Limiter
+
+func (m multiRedisLimiter) Allow() error {
+    for i, l := range m {
+        if err := l.Allow(); err != nil {
+            for _, allowed := range m[:i] {
+                allowed.ReportResult(nil)
+            }
+            return err
+        }
+    }
+    return nil
+}
+
+func (m multiRedisLimiter) ReportResult(result error) {
+    for _, l := range m {
+        l.ReportResult(result)
+    }
+}
+
+// 构建mongo的命令监视器, 多个插件的监视器会被合并
+func pluginCommandMonitor(info *connectInfo, plugins []instancePlugin) *event.CommandMonitor {
+    var monitors []*event.CommandMonitor
+    for _, p := range plugins {
+        if mp, ok := p.(mongoPlugin); ok {
+            if m := mp.commandMonitor(info); m != nil {
+                monitors = append(monitors, m)
+            }
+        }
+    }
+
+    switch len(monitors) {
+    case 0:
+        return nil
+    case 1:
+        return monitors[0]
+    }
+
+    return &event.CommandMonitor{
+        Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+            for _, m := range monitors {
+                if m.Started != nil {
+                    m.Started(ctx, e)
+                }
+            }
+        },
+        Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+            for _, m := range monitors {
+                if m.Succeeded != nil {
+                    m.Succeeded(ctx, e)
+                }
+            }
+        },
+        Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+            for _, m := range monitors {
+                if m.Failed != nil {
+                    m.Failed(ctx, e)
+                }
+            }
+        },
+    }
+}
+
+// 用插件装配已创建的实例, 返回装配后的实例
+func applyPlugins(info *connectInfo, instance interface{}, plugins []instancePlugin) interface{} {
+    for _, p := range plugins {
+        switch c := instance.(type) {
+        case redis.UniversalClient:
+            if rp, ok := p.(redisPlugin); ok {
+                rp.wrapRedis(info, c)
+            }
+        case *gorm.DB:
+            if gp, ok := p.(gormPlugin); ok {
+                gp.registerGorm(info, c)
+            }
+        case sarama.SyncProducer:
+            if kp, ok := p.(kafkaPlugin); ok {
+                instance = kp.wrapSyncProducer(info, c)
+            }
+        case sarama.AsyncProducer:
+            if kp, ok := p.(kafkaPlugin); ok {
+                instance = kp.wrapAsyncProducer(info, c)
+            }
+        }
+    }
+    return instance
+}
+
+// 在消息写入Input时先调用hook的异步生产者
+type hookedAsyncProducer struct {
+    sarama.AsyncProducer
+    input     chan *sarama.ProducerMessage
+    done      chan struct{}
+    closeOnce sync.Once
+}
+
+func newHookedAsyncProducer(p sarama.AsyncProducer, hook func(msg *sarama.ProducerMessage)) *hookedAsyncProducer {
+    m := &hookedAsyncProducer{
+        AsyncProducer: p,
+        input:         make(chan *sarama.ProducerMessage),
+        done:          make(chan struct{}),
+    }
+    go func() {
+        defer close(m.done)
+        for msg := range m.input {
+            hook(msg)
+            p.Input() <- msg
+        }
+    }()
+    return m
+}
+
+func (m *hookedAsyncProducer) Input() chan<- *sarama.ProducerMessage {
+    return m.input
+}
+
+// 关闭输入, 返回的chan在已接收的消息全部转交给原生产者后关闭
+func (m *hookedAsyncProducer) closeInput() <-chan struct{} {
+    m.closeOnce.Do(func() { close(m.input) })
+    return m.done
+}
+
+// 不等待已接收的消息转交完成, 原生产者会在转交完成后在后台关闭
+func (m *hookedAsyncProducer) AsyncClose() {
+    done := m.closeInput()
+    go func() {
+        <-done
+        m.AsyncProducer.AsyncClose()
+    }()
+}
+
+func (m *hookedAsyncProducer) Close() error {
+    <-m.closeInput()
+    return m.AsyncProducer.Close()
+}
@@ -0,0 +1,155 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/28
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "bytes"
+    "encoding/json"
+    "sort"
+    "time"
+
+    "github.com/pelletier/go-toml"
+    "github.com/zlyuancn/zerrors"
+)
+
+// 输出格式
+type DumpFormat string
+
+const (
+    JSONFormat DumpFormat = "json"
+    TOMLFormat DumpFormat = "toml"
+)
+
+// db状态
+type DBStatus struct {
+    DBName      string    `json:"dbname"`
+    DBType      DBType    `json:"dbtype"`
+    Connected   bool      `json:"connected"`
+    Optional    bool      `json:"optional"`         // 是否为可选的db
+    ConnectTime time.Time `json:"connect_time"`     // 未连接时为零值
+    LastError   string    `json:"last_error"`       // 最后一次连接失败的错误, 连接成功后清除
+    Active      string    `json:"active,omitempty"` // 虚拟db当前使用的成员
+    Handles     int       `json:"handles"`          // 未释放的句柄数
+
+    CircuitBreaker *CircuitBreakerStatus `json:"circuit_breaker,omitempty"` // 熔断器状态, 没有启用熔断器时为nil
+}
+
+// db配置, 敏感字段的值已被隐藏
+type DBConfigDump struct {
+    DBName string                 `json:"dbname"`
+    DBType DBType                 `json:"dbtype"`
+    Config map[string]interface{} `json:"config"`
+}
+
+// 列出所有db的状态, 按db名排序
+func (m *DBFactory) ListDBs() []*DBStatus {
+    m.mx.RLock()
+    out := make([]*DBStatus, 0, len(m.confs))
+    for dbname, conf := range m.confs {
+        status := &DBStatus{DBName: dbname, DBType: conf.dbtype, Optional: conf.optional}
+        if instance, ok := m.storage[dbname]; ok {
+            status.Connected = true
+            status.ConnectTime = instance.connectTime
+            status.Handles = instance.refs.count()
+            if v, ok := instance.instance.(iVirtualInstance); ok {
+                status.Active = v.activeMember()
+            }
+        }
+        if err, ok := m.lastErrors[dbname]; ok {
+            status.LastError = err.Error()
+        }
+        if conf.breaker != nil {
+            status.CircuitBreaker = conf.breaker.status()
+        }
+        out = append(out, status)
+    }
+    m.mx.RUnlock()
+
+    sort.Slice(out, func(i, j int) bool { return out[i].DBName < out[j].DBName })
+    return out
+}
+
+// 导出所有db的配置, 按db名排序, 敏感字段和来自密钥的字段的值会被隐藏
+func (m *DBFactory) DumpConfig() []*DBConfigDump {
+    m.mx.RLock()
+    out := make([]*DBConfigDump, 0, len(m.confs))
+    for dbname, conf := range m.confs {
+        out = append(out, &DBConfigDump{
+            DBName: dbname,
+            DBType: conf.dbtype,
+            Config: redactConfig(conf.config, conf.secretKeys...),
+        })
+    }
+    m.mx.RUnlock()
+
+    sort.Slice(out, func(i, j int) bool { return out[i].DBName < out[j].DBName })
+    return out
+}
+
+// 将ListDBs或DumpConfig的结果编码为指定格式
+//
+// toml的顶层必须是表, 所以列表会被放在dbs下
+func MarshalDump(v interface{}, format DumpFormat) ([]byte, error) {
+    switch format {
+    case JSONFormat:
+        return json.MarshalIndent(v, "", "  ")
+    case TOMLFormat:
+        // 先转为json再解码为基础类型, 让toml和json的字段名保持一致
+        bs, err := json.Marshal(v)
+        if err != nil {
+            return nil, err
+        }
+        decoder := json.NewDecoder(bytes.NewReader(bs))
+        decoder.UseNumber()
+        var data interface{}
+        if err = decoder.Decode(&data); err != nil {
+            return nil, err
+        }
+
+        table, ok := plainValue(data).(map[string]interface{})
+        if !ok {
+            table = map[string]interface{}{"dbs": plainValue(data)}
+        }
+        tree, err := toml.TreeFromMap(table)
+        if err != nil {
+            return nil, err
+        }
+        return []byte(tree.String()), nil
+    }
+    return nil, zerrors.NewSimplef("不支持的输出格式<%s>", format)
+}
+
+// 将json解码出的值转为toml可以表示的值, null会被去掉
+func plainValue(v interface{}) interface{} {
+    switch vv := v.(type) {
+    case json.Number:
+        if n, err := vv.Int64(); err == nil {
+            return n
+        }
+        f, _ := vv.Float64()
+        return f
+    case []interface{}:
+        out := make([]interface{}, 0, len(vv))
+        for _, item := range vv {
+            if item != nil {
+                out = append(out, plainValue(item))
+            }
+        }
+        return out
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(vv))
+        for k, item := range vv {
+            if item != nil {
+                out[k] = plainValue(item)
+            }
+        }
+        return out
+    }
+    return v
+}
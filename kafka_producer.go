@@ -74,7 +74,7 @@ func AddKafkaProducerConfig(dbname string, conf *KafkaProducerConfig) {
 func GetKafkaProducer(dbname string) (sarama.SyncProducer, error) {
     a := GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != KafkaProducer {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.Type())
@@ -98,7 +98,7 @@ func MustKafkaProducer(dbname string) sarama.SyncProducer {
 func GetKafkaAsyncProducer(dbname string) (sarama.AsyncProducer, error) {
     a := GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != KafkaProducer {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.Type())
@@ -0,0 +1,239 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/1
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "sort"
+    "strings"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 选择profile的环境变量, 没有使用WithProfile时生效
+const ProfileEnv = "ZDB_PROFILE"
+
+// 加载配置时多个分片的错误, 每个元素对应一个分片
+type ShardErrors []error
+
+func (e ShardErrors) Error() string {
+    msgs := make([]string, len(e))
+    for i, err := range e {
+        msgs[i] = err.Error()
+    }
+    return strings.Join(msgs, "\n")
+}
+
+// 配置布局, 决定从配置树的哪里找出分片
+type configLayout struct {
+    prefix    string // 分片key的前缀
+    prefixSet bool   // 是否用WithDBPrefix设置了前缀
+    section   string // 分片所在的节点路径, 用.分隔, 为空表示顶层
+    profile   string // profile名, profile中的分片会覆盖顶层的同名分片
+}
+
+// 根节点中分片key的前缀, 设置了section并且没有用WithDBPrefix设置前缀时不使用前缀
+func (l configLayout) shardPrefix() string {
+    if l.section != "" && !l.prefixSet {
+        return ""
+    }
+    return l.prefix
+}
+
+// 从配置树中找出所有分片, 返回 dbname: 分片
+//
+// 在根节点(顶层或section)中, 以前缀开头的表是一个分片, dbname为去掉前缀后的key, section中默认没有前缀, 见shardPrefix.
+// 如果这个表没有dbtype和url字段, 只包含表, 并且key(去掉prefix后)是已注册的db类型, 那么它是一个分组, 它下面的每个表都是该类型的分片.
+// 设置了profile时, 会用同样的方式从 profile.section 中找出分片, 并覆盖到根节点的同名分片上.
+// 最后处理分片的继承和模板
+func (m *DBFactory) findShards(settings map[string]interface{}) (map[string]map[string]interface{}, error) {
+    root := lookupTable(settings, m.layout.section)
+    if root == nil {
+        m.log.Warn("配置中没有分片所在的节点", F("section", m.layout.section))
+    }
+    shards, err := m.scanShards(root, nil)
+    if err != nil {
+        return nil, err
+    }
+    if root != nil && len(shards) == 0 {
+        m.log.Warn("节点中没有找到分片", F("section", m.layout.section), F("prefix", m.layout.shardPrefix()))
+    }
+    if m.layout.profile != "" {
+        if err = m.applyProfile(settings, shards); err != nil {
+            return nil, err
+        }
+    }
+    return m.expandShards(shards)
+}
+
+// 将profile中的分片覆盖到同名分片上
+func (m *DBFactory) applyProfile(settings map[string]interface{}, shards map[string]map[string]interface{}) error {
+    profile := lookupTable(settings, m.layout.profile)
+    if profile == nil {
+        m.log.Warn("配置中没有profile", F("profile", m.layout.profile))
+        return nil
+    }
+    overlays, err := m.scanShards(lookupTable(profile, m.layout.section), shards)
+    if err != nil {
+        return err
+    }
+    for dbname, overlay := range overlays {
+        if base, ok := shards[dbname]; ok {
+            overlay = mergeShard(base, overlay)
+        }
+        shards[dbname] = overlay
+    }
+    return nil
+}
+
+// 找出一个节点下的分片, 和base中的分片同名的表总是一个分片(如profile中只覆盖了部分字段的分片)
+func (m *DBFactory) scanShards(root map[string]interface{}, base map[string]map[string]interface{}) (map[string]map[string]interface{}, error) {
+    shards := make(map[string]map[string]interface{})
+    add := func(dbname string, shard map[string]interface{}) error {
+        dbname = strings.ToLower(dbname)
+        if _, ok := shards[dbname]; ok {
+            return zerrors.NewSimplef("重复的dbname<%s>", dbname)
+        }
+        shards[dbname] = shard
+        return nil
+    }
+
+    prefix := m.layout.shardPrefix()
+    for key, v := range root {
+        if !strings.HasPrefix(key, prefix) {
+            continue
+        }
+        table, ok := v.(map[string]interface{})
+        if !ok {
+            m.log.Warn("忽略不是分片的配置", F("key", key))
+            continue
+        }
+
+        name := key[len(prefix):]
+        if !hasAnyField(table, DBTypeField, URLField) {
+            // 按db类型分组
+            if _, ok := factoryStorage[DBType(strings.ToLower(name))]; ok && isShardGroup(table) {
+                for dbname, sv := range table {
+                    shard := sv.(map[string]interface{})
+                    if _, ok := shardField(shard, DBTypeField); !ok {
+                        shard = mergeShard(shard, map[string]interface{}{DBTypeField: name})
+                    }
+                    if err := add(dbname, shard); err != nil {
+                        return nil, err
+                    }
+                }
+                continue
+            }
+            // 没有前缀时任何表都会被扫描到, 忽略不是分片的表, 如profile
+            if _, ok := base[strings.ToLower(name)]; !ok && prefix == "" && !hasAnyField(table, ExtendsField, AbstractField) {
+                continue
+            }
+        }
+
+        if err := add(name, table); err != nil {
+            return nil, err
+        }
+    }
+    return shards, nil
+}
+
+// 构建并添加多个分片, 所有分片都构建成功后才会添加, 否则返回ShardErrors
+func (m *DBFactory) addShards(shards map[string]map[string]interface{}) error {
+    dbnames := make([]string, 0, len(shards))
+    for dbname := range shards {
+        dbnames = append(dbnames, dbname)
+    }
+    sort.Strings(dbnames)
+
+    var errs ShardErrors
+    confs := make([]*dbConfig, len(dbnames))
+    for i, dbname := range dbnames {
+        if dbname == "" {
+            errs = append(errs, zerrors.NewSimple("dbname为空"))
+            continue
+        }
+        conf, err := m.buildDBConfig(dbname, shards[dbname])
+        if err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        confs[i] = conf
+    }
+    if len(errs) > 0 {
+        return errs
+    }
+
+    for i, dbname := range dbnames {
+        m.setDBConfig(dbname, confs[i])
+    }
+    return nil
+}
+
+// 根据.分隔的路径获取节点, key不区分大小写, 路径为空时返回settings, 不存在时返回nil
+func lookupTable(settings map[string]interface{}, path string) map[string]interface{} {
+    if path == "" {
+        return settings
+    }
+    table := settings
+    for _, key := range strings.Split(path, ".") {
+        v, _ := shardField(table, key)
+        next, ok := v.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        table = next
+    }
+    return table
+}
+
+// 表是否是按db类型的分组, 分组中只能有表, 存在其它字段(如extends)时是一个缺少dbtype的分片
+func isShardGroup(table map[string]interface{}) bool {
+    if len(table) == 0 {
+        return false
+    }
+    for _, v := range table {
+        if _, ok := v.(map[string]interface{}); !ok {
+            return false
+        }
+    }
+    return true
+}
+
+// 获取分片中的字段, key不区分大小写
+func shardField(shard map[string]interface{}, key string) (interface{}, bool) {
+    for k, v := range shard {
+        if strings.EqualFold(k, key) {
+            return v, true
+        }
+    }
+    return nil, false
+}
+
+// 分片中是否存在任意一个字段, key不区分大小写
+func hasAnyField(shard map[string]interface{}, keys ...string) bool {
+    for _, key := range keys {
+        if _, ok := shardField(shard, key); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// 将overlay中的字段覆盖到base上, 返回新的分片, key不区分大小写
+func mergeShard(base, overlay map[string]interface{}) map[string]interface{} {
+    out := make(map[string]interface{}, len(base)+len(overlay))
+    for k, v := range base {
+        if _, ok := shardField(overlay, k); !ok {
+            out[k] = v
+        }
+    }
+    for k, v := range overlay {
+        out[k] = v
+    }
+    return out
+}
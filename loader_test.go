@@ -0,0 +1,233 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/1
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/pelletier/go-toml"
+)
+
+// 加载后每个db的类型和第一个地址, 如 redis@h1
+func loadedDBs(m *DBFactory) map[string]string {
+    out := make(map[string]string, len(m.confs))
+    for dbname, conf := range m.confs {
+        desc := string(conf.dbtype)
+        if c, ok := conf.config.(*RedisConfig); ok && len(c.Address) > 0 {
+            desc += "@" + c.Address[0]
+        }
+        out[dbname] = desc
+    }
+    return out
+}
+
+func TestLoadTomlLayout(t *testing.T) {
+    tests := []struct {
+        name    string
+        opts    []Options
+        in      string
+        want    map[string]string
+        wantErr string // 错误信息中应包含的内容, 为空时不应返回错误
+    }{
+        {
+            name: "prefix",
+            in: `
+[zdb_a]
+dbtype = "redis"
+Address = ["h1"]
+[other]
+dbtype = "redis"
+`,
+            want: map[string]string{"a": "redis@h1"},
+        },
+        {
+            name: "custom prefix",
+            opts: []Options{WithDBPrefix("db_")},
+            in: `
+[db_a]
+dbtype = "redis"
+Address = ["h1"]
+[zdb_b]
+dbtype = "redis"
+`,
+            want: map[string]string{"a": "redis@h1"},
+        },
+        {
+            name: "type group",
+            in: `
+[zdb_redis.a]
+Address = ["h1"]
+[zdb_redis.b]
+Address = ["h2"]
+`,
+            want: map[string]string{"a": "redis@h1", "b": "redis@h2"},
+        },
+        {
+            name: "section without prefix",
+            opts: []Options{WithConfigSection("database.main")},
+            in: `
+[database.main.a]
+dbtype = "redis"
+Address = ["h1"]
+[database.main.redis.b]
+Address = ["h2"]
+[database.main.notes]
+text = "not a shard"
+[zdb_c]
+dbtype = "redis"
+`,
+            want: map[string]string{"a": "redis@h1", "b": "redis@h2"},
+        },
+        {
+            name: "section with prefix",
+            opts: []Options{WithConfigSection("database"), WithDBPrefix("zdb_")},
+            in: `
+[database.zdb_a]
+dbtype = "redis"
+Address = ["h1"]
+[database.b]
+dbtype = "redis"
+`,
+            want: map[string]string{"a": "redis@h1"},
+        },
+        {
+            name: "profile overrides fields",
+            opts: []Options{WithProfile("dev")},
+            in: `
+[zdb_a]
+dbtype = "redis"
+Address = ["prod"]
+DB = 1
+[zdb_b]
+dbtype = "redis"
+Address = ["h2"]
+[dev.zdb_a]
+Address = ["dev"]
+[dev.zdb_c]
+dbtype = "redis"
+Address = ["h3"]
+`,
+            want: map[string]string{"a": "redis@dev", "b": "redis@h2", "c": "redis@h3"},
+        },
+        {
+            name: "profile with section",
+            opts: []Options{WithProfile("dev"), WithConfigSection("database")},
+            in: `
+[database.a]
+dbtype = "redis"
+Address = ["prod"]
+[dev.database.a]
+Address = ["dev"]
+`,
+            want: map[string]string{"a": "redis@dev"},
+        },
+        {
+            name: "missing profile is ignored",
+            opts: []Options{WithProfile("dev")},
+            in: `
+[zdb_a]
+dbtype = "redis"
+Address = ["h1"]
+`,
+            want: map[string]string{"a": "redis@h1"},
+        },
+        {
+            name: "extends in a group",
+            in: `
+[zdb_base]
+abstract = true
+dbtype = "redis"
+Address = ["h1"]
+[zdb_redis.a]
+extends = "base"
+DB = 2
+`,
+            want: map[string]string{"a": "redis@h1"},
+        },
+        {
+            name: "extends without dbtype",
+            in: `
+[zdb_base]
+dbtype = "redis"
+Address = ["h1"]
+[zdb_child]
+extends = "base"
+`,
+            want: map[string]string{"base": "redis@h1", "child": "redis@h1"},
+        },
+        {
+            name: "dbtype named shard with extends is not a group",
+            in: `
+[zdb_base]
+dbtype = "redis"
+Address = ["h1"]
+[zdb_redis]
+extends = "base"
+Address = ["h2"]
+`,
+            want: map[string]string{"base": "redis@h1", "redis": "redis@h2"},
+        },
+        {
+            name: "dbtype named shard without dbtype",
+            in: `
+[zdb_mysql]
+Host = "h"
+`,
+            wantErr: "dbtype必须存在",
+        },
+        {
+            name: "dbtype named shard extending nothing",
+            in: `
+[zdb_redis]
+extends = "base"
+`,
+            wantErr: "base",
+        },
+        {
+            name: "duplicate dbname",
+            in: `
+[zdb_a]
+dbtype = "redis"
+[zdb_redis.A]
+Address = ["h1"]
+`,
+            wantErr: "重复的dbname<a>",
+        },
+    }
+    for _, tt := range tests {
+        tree, err := toml.Load(tt.in)
+        if err != nil {
+            t.Fatalf("%s: toml.Load: %v", tt.name, err)
+        }
+
+        m := New(tt.opts...)
+        err = m.AddTomlTree(tree)
+        switch {
+        case tt.wantErr == "" && err != nil:
+            t.Errorf("%s: err = %v, want nil", tt.name, err)
+            continue
+        case tt.wantErr != "" && err == nil:
+            t.Errorf("%s: err = nil, want %q", tt.name, tt.wantErr)
+            continue
+        case tt.wantErr != "":
+            if !strings.Contains(err.Error(), tt.wantErr) {
+                t.Errorf("%s: err = %q, want it to contain %q", tt.name, err, tt.wantErr)
+            }
+            if len(m.confs) != 0 {
+                t.Errorf("%s: added %v after an error", tt.name, loadedDBs(m))
+            }
+            continue
+        }
+        if got := loadedDBs(m); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: dbs = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,71 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/18
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "go.uber.org/zap"
+)
+
+// 日志字段
+type LogField struct {
+    Key   string
+    Value interface{}
+}
+
+// 构建一个日志字段
+func F(key string, value interface{}) LogField {
+    return LogField{Key: key, Value: value}
+}
+
+// 工厂使用的日志记录器
+type ILogger interface {
+    Debug(msg string, fields ...LogField)
+    Info(msg string, fields ...LogField)
+    Warn(msg string, fields ...LogField)
+    Error(msg string, fields ...LogField)
+}
+
+// 什么都不做的日志记录器, 工厂默认使用它
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...LogField) {}
+func (nopLogger) Info(string, ...LogField)  {}
+func (nopLogger) Warn(string, ...LogField)  {}
+func (nopLogger) Error(string, ...LogField) {}
+
+// zap日志适配器
+type zapLogger struct {
+    log *zap.Logger
+}
+
+// 将zap.Logger包装为ILogger
+func NewZapLogger(log *zap.Logger) ILogger {
+    return &zapLogger{log: log.WithOptions(zap.AddCallerSkip(1))}
+}
+
+func (m *zapLogger) fields(fields []LogField) []zap.Field {
+    out := make([]zap.Field, len(fields))
+    for i, f := range fields {
+        out[i] = zap.Any(f.Key, f.Value)
+    }
+    return out
+}
+
+func (m *zapLogger) Debug(msg string, fields ...LogField) {
+    m.log.Debug(msg, m.fields(fields)...)
+}
+func (m *zapLogger) Info(msg string, fields ...LogField) {
+    m.log.Info(msg, m.fields(fields)...)
+}
+func (m *zapLogger) Warn(msg string, fields ...LogField) {
+    m.log.Warn(msg, m.fields(fields)...)
+}
+func (m *zapLogger) Error(msg string, fields ...LogField) {
+    m.log.Error(msg, m.fields(fields)...)
+}
@@ -0,0 +1,258 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/14
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/go-redis/redis"
+    "github.com/jinzhu/gorm"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/seefan/gossdb"
+)
+
+// 指标名前缀
+const MetricsNamespace = "zdb"
+
+var metricsLabels = []string{"dbname", "dbtype"}
+
+// 工厂指标, 为nil时所有方法都不做任何事
+type factoryMetrics struct {
+    connectTotal    *prometheus.CounterVec
+    connectFailures *prometheus.CounterVec
+    connectLatency  *prometheus.HistogramVec
+    instances       *prometheus.GaugeVec
+    reconnects      *prometheus.CounterVec
+    closeErrors     *prometheus.CounterVec
+    handles         *prometheus.GaugeVec
+    leakedHandles   *prometheus.CounterVec
+    releaseTimeouts *prometheus.CounterVec
+}
+
+func newFactoryMetrics(reg prometheus.Registerer, factory *DBFactory) *factoryMetrics {
+    m := &factoryMetrics{
+        connectTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: MetricsNamespace,
+            Name:      "connect_total",
+            Help:      "连接尝试次数",
+        }, metricsLabels),
+        connectFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: MetricsNamespace,
+            Name:      "connect_failures_total",
+            Help:      "连接失败次数",
+        }, metricsLabels),
+        connectLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+            Namespace: MetricsNamespace,
+            Name:      "connect_duration_seconds",
+            Help:      "连接耗时",
+            Buckets:   prometheus.DefBuckets,
+        }, metricsLabels),
+        instances: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+            Namespace: MetricsNamespace,
+            Name:      "instances",
+            Help:      "当前已连接的实例数",
+        }, metricsLabels),
+        reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: MetricsNamespace,
+            Name:      "reconnects_total",
+            Help:      "重连次数",
+        }, metricsLabels),
+        closeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: MetricsNamespace,
+            Name:      "close_errors_total",
+            Help:      "关闭连接失败次数",
+        }, metricsLabels),
+        handles: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+            Namespace: MetricsNamespace,
+            Name:      "handles",
+            Help:      "当前未释放的句柄数",
+        }, metricsLabels),
+        leakedHandles: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: MetricsNamespace,
+            Name:      "leaked_handles_total",
+            Help:      "没有释放就被回收的句柄数",
+        }, metricsLabels),
+        releaseTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Namespace: MetricsNamespace,
+            Name:      "release_timeouts_total",
+            Help:      "关闭实例时等待句柄释放超时的次数",
+        }, metricsLabels),
+    }
+
+    reg.MustRegister(
+        m.connectTotal,
+        m.connectFailures,
+        m.connectLatency,
+        m.instances,
+        m.reconnects,
+        m.closeErrors,
+        m.handles,
+        m.leakedHandles,
+        m.releaseTimeouts,
+        newPoolCollector(factory),
+    )
+    return m
+}
+
+// 记录一次连接
+func (m *factoryMetrics) observeConnect(dbname string, dbtype DBType, latency time.Duration, reconnect bool, err error) {
+    if m == nil {
+        return
+    }
+
+    labels := prometheus.Labels{"dbname": dbname, "dbtype": string(dbtype)}
+    m.connectTotal.With(labels).Inc()
+    m.connectLatency.With(labels).Observe(latency.Seconds())
+    if err != nil {
+        m.connectFailures.With(labels).Inc()
+        return
+    }
+
+    if reconnect {
+        m.reconnects.With(labels).Inc()
+    }
+    m.instances.With(labels).Set(1)
+}
+
+// 记录一次关闭, replaced表示关闭的是已被新实例替换掉的旧实例
+func (m *factoryMetrics) observeClose(dbname string, dbtype DBType, replaced bool, err error) {
+    if m == nil {
+        return
+    }
+
+    labels := prometheus.Labels{"dbname": dbname, "dbtype": string(dbtype)}
+    if !replaced {
+        m.instances.With(labels).Set(0)
+    }
+    if err != nil {
+        m.closeErrors.With(labels).Inc()
+    }
+}
+
+// 记录一次句柄获取或释放
+func (m *factoryMetrics) observeHandle(dbname string, dbtype DBType, acquired bool) {
+    if m == nil {
+        return
+    }
+
+    labels := prometheus.Labels{"dbname": dbname, "dbtype": string(dbtype)}
+    if acquired {
+        m.handles.With(labels).Inc()
+    } else {
+        m.handles.With(labels).Dec()
+    }
+}
+
+// 记录一个泄漏的句柄
+func (m *factoryMetrics) observeLeak(dbname string, dbtype DBType) {
+    if m == nil {
+        return
+    }
+    m.leakedHandles.With(prometheus.Labels{"dbname": dbname, "dbtype": string(dbtype)}).Inc()
+}
+
+// 记录一次等待句柄释放超时
+func (m *factoryMetrics) observeReleaseTimeout(dbname string, dbtype DBType) {
+    if m == nil {
+        return
+    }
+    m.releaseTimeouts.With(prometheus.Labels{"dbname": dbname, "dbtype": string(dbtype)}).Inc()
+}
+
+// 从实例中读取连接池状态, 不支持的实例返回nil
+//
+// key为指标名, 如 total_conns, idle_conns, in_use_conns
+func poolStats(instance interface{}) map[string]float64 {
+    switch c := instance.(type) {
+    case interface{ PoolStats() *redis.PoolStats }:
+        stats := c.PoolStats()
+        return map[string]float64{
+            "total_conns":    float64(stats.TotalConns),
+            "idle_conns":     float64(stats.IdleConns),
+            "in_use_conns":   float64(stats.TotalConns - stats.IdleConns),
+            "timeouts_total": float64(stats.Timeouts),
+            "hits_total":     float64(stats.Hits),
+            "misses_total":   float64(stats.Misses),
+        }
+    case *gorm.DB:
+        stats := c.DB().Stats()
+        return map[string]float64{
+            "total_conns":  float64(stats.OpenConnections),
+            "idle_conns":   float64(stats.Idle),
+            "in_use_conns": float64(stats.InUse),
+            "wait_total":   float64(stats.WaitCount),
+        }
+    case *gossdb.Connectors:
+        var total, active, waiting, max int
+        if _, err := fmt.Sscanf(c.Info(), "pool size:%d\tactived client:%d\twait create:%d\tconfig max pool size:%d",
+            &total, &active, &waiting, &max); err != nil {
+            return nil
+        }
+        return map[string]float64{
+            "total_conns":  float64(total),
+            "idle_conns":   float64(total - active),
+            "in_use_conns": float64(active),
+            "waiting":      float64(waiting),
+        }
+    }
+    return nil
+}
+
+// 连接池指标
+type poolMetric struct {
+    desc      *prometheus.Desc
+    valueType prometheus.ValueType
+}
+
+// 连接池指标收集器, 每次被prometheus抓取时从实例中读取连接池状态
+type poolCollector struct {
+    factory *DBFactory
+    metrics map[string]poolMetric
+}
+
+func newPoolCollector(factory *DBFactory) *poolCollector {
+    m := &poolCollector{factory: factory, metrics: make(map[string]poolMetric)}
+    add := func(name string, valueType prometheus.ValueType, help string) {
+        desc := prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "pool", name), help, metricsLabels, nil)
+        m.metrics[name] = poolMetric{desc: desc, valueType: valueType}
+    }
+    add("total_conns", prometheus.GaugeValue, "连接池中的连接总数")
+    add("idle_conns", prometheus.GaugeValue, "连接池中的空闲连接数")
+    add("in_use_conns", prometheus.GaugeValue, "正在使用的连接数")
+    add("waiting", prometheus.GaugeValue, "正在等待连接的请求数")
+    add("wait_total", prometheus.CounterValue, "等待连接的总次数")
+    add("timeouts_total", prometheus.CounterValue, "获取连接超时的总次数")
+    add("hits_total", prometheus.CounterValue, "从连接池中获取到空闲连接的总次数")
+    add("misses_total", prometheus.CounterValue, "连接池中没有空闲连接的总次数")
+    return m
+}
+
+func (m *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+    for _, metric := range m.metrics {
+        ch <- metric.desc
+    }
+}
+
+// 只在锁内复制实例列表, 读取连接池状态时不持有锁, 避免抓取期间阻塞连接和替换配置
+func (m *poolCollector) Collect(ch chan<- prometheus.Metric) {
+    m.factory.mx.RLock()
+    instances := make(map[string]*DBInstance, len(m.factory.storage))
+    for dbname, instance := range m.factory.storage {
+        instances[dbname] = instance
+    }
+    m.factory.mx.RUnlock()
+
+    for dbname, instance := range instances {
+        for name, v := range poolStats(instance.instance) {
+            metric := m.metrics[name]
+            ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, v, dbname, string(instance.dbtype))
+        }
+    }
+}
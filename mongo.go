@@ -9,33 +9,71 @@
 package zdbfactory
 
 import (
-    "time"
+    "context"
+    "net/url"
+    "strings"
 
     "github.com/zlyuancn/zerrors"
     "github.com/zlyuancn/zmongo"
+    "go.mongodb.org/mongo-driver/event"
+    "go.mongodb.org/mongo-driver/mongo"
+    "go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type mongoFactory int
 
 var _ IDBFactory = (*mongoFactory)(nil)
+var _ iDBFactoryWithPlugins = (*mongoFactory)(nil)
+var _ IDBPinger = (*mongoFactory)(nil)
 
 type MongoConfig struct {
-    Address       []string // 连接地址, 如: 127.0.0.1:27017
-    DBName        string   // 库名
-    UserName      string   // 用户名
-    Password      string   // 密码
-    PoolSize      uint64   // 连接池的数量
-    DialTimeout   int64    // 连接超时(毫秒
-    DoTimeout     int64    // 操作超时(毫秒
-    SocketTimeout int64    // Socket超时
-    Ping          bool     // 开始连接时是否ping确认连接情况
+    Address       []string          // 连接地址, 如: 127.0.0.1:27017
+    DBName        string            // 库名
+    UserName      string            // 用户名
+    Password      string            // 密码
+    PoolSize      uint64            // 连接池的数量
+    DialTimeout   Duration          // 连接超时(毫秒
+    DoTimeout     Duration          // 操作超时(毫秒
+    SocketTimeout Duration          // Socket超时(毫秒
+    Ping          bool              // 开始连接时是否ping确认连接情况
+    SRV           bool              // 通过dns的srv记录查找地址, 同mongodb+srv://, Address只能有一个不带端口的域名
+    Options       map[string]string // 驱动参数, 同连接串的查询参数, 如 authSource, replicaSet
+}
+
+// 驱动参数对应的连接串, 包含密码, 不能输出到日志. 没有驱动参数且不使用srv时返回空字符串
+func (c *MongoConfig) driverURI() string {
+    if len(c.Options) == 0 && !c.SRV {
+        return ""
+    }
+    scheme := "mongodb"
+    if c.SRV {
+        scheme = "mongodb+srv"
+    }
+    query := make(url.Values, len(c.Options))
+    for k, v := range c.Options {
+        query.Set(k, v)
+    }
+    u := &url.URL{Scheme: scheme, Host: strings.Join(c.Address, ","), Path: "/" + c.DBName, RawQuery: query.Encode()}
+    // 驱动要求authSource等认证参数和用户名一起出现在连接串中
+    if c.UserName != "" {
+        u.User = url.UserPassword(c.UserName, c.Password)
+    }
+    return u.String()
 }
 
 func (mongoFactory) MakeEmptyConfig() interface{} {
     return new(MongoConfig)
 }
 
-func (mongoFactory) Connect(config interface{}) (interface{}, error) {
+func (m mongoFactory) Connect(config interface{}) (interface{}, error) {
+    return m.connect(config, nil)
+}
+
+func (m mongoFactory) connectWithPlugins(info *connectInfo, plugins []instancePlugin) (interface{}, error) {
+    return m.connect(info.config, pluginCommandMonitor(info, plugins))
+}
+
+func (mongoFactory) connect(config interface{}, monitor *event.CommandMonitor) (interface{}, error) {
     var conf *MongoConfig
     switch c := config.(type) {
     case *MongoConfig:
@@ -46,16 +84,24 @@ func (mongoFactory) Connect(config interface{}) (interface{}, error) {
         return nil, zerrors.NewSimple("非*MongoConfig结构")
     }
 
-    c, err := zmongo.New(&zmongo.Config{
+    zconf := &zmongo.Config{
         Address:       conf.Address,
         DBName:        conf.DBName,
         UserName:      conf.UserName,
         Password:      conf.Password,
         PoolSize:      conf.PoolSize,
-        DialTimeout:   time.Duration(conf.DialTimeout * 1e6),
-        DoTimeout:     time.Duration(conf.DoTimeout * 1e6),
-        SocketTimeout: time.Duration(conf.SocketTimeout * 1e6),
-    })
+        DialTimeout:   conf.DialTimeout.Duration(),
+        DoTimeout:     conf.DoTimeout.Duration(),
+        SocketTimeout: conf.SocketTimeout.Duration(),
+    }
+
+    var c *zmongo.Client
+    var err error
+    if uri := conf.driverURI(); monitor == nil && uri == "" {
+        c, err = zmongo.New(zconf)
+    } else {
+        c, err = newMongoClient(zconf, uri, monitor)
+    }
     if err != nil {
         return nil, zerrors.WrapSimple(err, "连接失败")
     }
@@ -68,6 +114,75 @@ func (mongoFactory) Connect(config interface{}) (interface{}, error) {
 
     return c, nil
 }
+
+// 创建mongo客户端, 除了连接串中的驱动参数和命令监视器以外与zmongo.New一致
+//
+// uri不为空时先应用连接串, 配置中的字段会覆盖连接串中的同名参数, 连接串中的认证参数(如authSource)优先于DBName
+func newMongoClient(conf *zmongo.Config, uri string, monitor *event.CommandMonitor) (*zmongo.Client, error) {
+    m := &zmongo.Client{
+        Config: *conf,
+    }
+    if m.DialTimeout == 0 {
+        m.DialTimeout = zmongo.DefaultDialTimeout
+    }
+    if m.DoTimeout == 0 {
+        m.DoTimeout = zmongo.DefaultDoTimeout
+    }
+    if m.SocketTimeout == 0 {
+        m.SocketTimeout = zmongo.DefaultSocketTimeout
+    }
+
+    opt := options.Client()
+    if uri != "" {
+        if err := opt.ApplyURI(uri).Validate(); err != nil {
+            return nil, err
+        }
+    } else {
+        opt.Hosts = m.Address
+    }
+    if m.PoolSize > 0 || opt.MaxPoolSize == nil {
+        opt.MaxPoolSize = &m.PoolSize
+    }
+    opt.ConnectTimeout = &m.DialTimeout
+    opt.SocketTimeout = &m.SocketTimeout
+    opt.Monitor = monitor
+    if m.UserName != "" {
+        auth := &options.Credential{
+            AuthSource: m.DBName,
+            Username:   m.UserName,
+            Password:   m.Password,
+        }
+        if opt.Auth != nil {
+            auth.AuthMechanism = opt.Auth.AuthMechanism
+            auth.AuthMechanismProperties = opt.Auth.AuthMechanismProperties
+            if opt.Auth.AuthSource != "" {
+                auth.AuthSource = opt.Auth.AuthSource
+            }
+        }
+        opt.Auth = auth
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), m.DialTimeout)
+    defer cancel()
+
+    client, err := mongo.Connect(ctx, opt)
+    if err != nil {
+        return nil, err
+    }
+
+    m.Client = client
+    return m, nil
+}
+
+func (mongoFactory) Ping(ctx context.Context, dbinstance interface{}) error {
+    c, ok := dbinstance.(*zmongo.Client)
+    if !ok {
+        return zerrors.NewSimple("非*zmongo.Client结构")
+    }
+
+    return c.Client.Ping(ctx, nil)
+}
+
 func (mongoFactory) Close(dbinstance interface{}) error {
     c, ok := dbinstance.(*zmongo.Client)
     if !ok {
@@ -86,7 +201,7 @@ func AddMongoConfig(dbname string, conf *MongoConfig) {
 func GetMongo(dbname string) (*zmongo.Client, error) {
     a := defaultDBFactory.GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != Mongo {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.dbtype)
@@ -9,7 +9,9 @@
 package zdbfactory
 
 import (
+    "context"
     "fmt"
+    "net/url"
 
     "github.com/jinzhu/gorm"
     _ "github.com/jinzhu/gorm/dialects/mysql"
@@ -19,15 +21,17 @@ import (
 type mysqlFactory int
 
 var _ IDBFactory = (*mysqlFactory)(nil)
+var _ IDBPinger = (*mysqlFactory)(nil)
 
 type MysqlConfig struct {
-    Host        string // 主机地址
-    DBName      string // 库名
-    UserName    string // 用户名
-    Password    string // 密码
-    MinPoolSize int    // 最小连接池数
-    MaxPoolSize int    // 最大连接池个数
-    Ping        bool   // 开始连接时是否ping确认连接情况
+    Host        string            // 主机地址
+    DBName      string            // 库名
+    UserName    string            // 用户名
+    Password    string            // 密码
+    MinPoolSize int               // 最小连接池数
+    MaxPoolSize int               // 最大连接池个数
+    Params      map[string]string // 额外的dsn参数, 会覆盖默认的charset, parseTime和loc
+    Ping        bool              // 开始连接时是否ping确认连接情况
 }
 
 func (mysqlFactory) MakeEmptyConfig() interface{} {
@@ -45,11 +49,16 @@ func (mysqlFactory) Connect(config interface{}) (interface{}, error) {
         return nil, zerrors.NewSimple("非*MysqlConfig结构")
     }
 
-    dbsource := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+    params := url.Values{"charset": {"utf8mb4"}, "parseTime": {"True"}, "loc": {"Local"}}
+    for k, v := range conf.Params {
+        params.Set(k, v)
+    }
+    dbsource := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
         conf.UserName,
         conf.Password,
         conf.Host,
         conf.DBName,
+        params.Encode(),
     )
     c, err := gorm.Open("mysql", dbsource)
     if err != nil {
@@ -67,6 +76,15 @@ func (mysqlFactory) Connect(config interface{}) (interface{}, error) {
     db.SetMaxOpenConns(conf.MaxPoolSize)
     return c, nil
 }
+func (mysqlFactory) Ping(ctx context.Context, dbinstance interface{}) error {
+    c, ok := dbinstance.(*gorm.DB)
+    if !ok {
+        return zerrors.NewSimple("非*gorm.DB结构")
+    }
+
+    return c.DB().PingContext(ctx)
+}
+
 func (mysqlFactory) Close(dbinstance interface{}) error {
     c, ok := dbinstance.(*gorm.DB)
     if !ok {
@@ -85,7 +103,7 @@ func AddMysqlConfig(dbname string, conf *MysqlConfig) {
 func GetMysql(dbname string) (*gorm.DB, error) {
     a := defaultDBFactory.GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != Mysql {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.dbtype)
@@ -94,6 +112,19 @@ func GetMysql(dbname string) (*gorm.DB, error) {
     return a.Instance().(*gorm.DB), nil
 }
 
+// 获取mysql分片组中key对应的mysql实例
+func GetMysqlShard(group, key string) (*gorm.DB, error) {
+    a, err := defaultDBFactory.GetShard(group, key)
+    if err != nil {
+        return nil, err
+    }
+    if a.Type() != Mysql {
+        return nil, zerrors.NewSimplef("分片组<%s>的成员是<%v>类型", group, a.dbtype)
+    }
+
+    return a.Instance().(*gorm.DB), nil
+}
+
 // 获取mysql实例, 该实例如果不是mysql类型会panic
 func MustGetMysql(dbname string) *gorm.DB {
     c, err := GetMysql(dbname)
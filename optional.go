@@ -0,0 +1,98 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/11
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "strings"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 为true时这个db是可选的, 它连接失败不会让ConnectAllDB失败, 而是记录错误并在后台重试
+const OptionalField = "optional"
+
+// 可选db的默认后台重试间隔
+const DefaultOptionalRetryInterval = time.Second * 10
+
+// 可选db还未连接成功时获取实例返回的错误, 可以用IsDBNotAvailable判断
+var ErrDBNotAvailable = zerrors.NewSimple("db暂时不可用")
+
+// 判断错误是否为可选db还未连接成功
+func IsDBNotAvailable(err error) bool {
+    return zerrors.Cause(err) == ErrDBNotAvailable
+}
+
+// 解析分片的optional
+func parseOptional(dbname string, shard map[string]interface{}) (bool, error) {
+    v, ok := shardField(shard, OptionalField)
+    if !ok {
+        return false, nil
+    }
+    optional, ok := v.(bool)
+    if !ok {
+        return false, zerrors.NewSimplef("<%s>的%s必须为bool类型", dbname, OptionalField)
+    }
+    return optional, nil
+}
+
+// 在后台重试连接可选db, 直到连接成功, 配置被替换或移除, 或者调用了CloseAllDb. 调用者需要持有锁
+//
+// 重试时在锁外连接, 不会阻塞其它db的获取
+func (m *DBFactory) retryOptional(dbname string, conf *dbConfig) {
+    if m.retrying[dbname] == conf {
+        return
+    }
+    m.retrying[dbname] = conf
+
+    go func() {
+        for {
+            time.Sleep(m.optionalRetry)
+
+            m.mx.Lock()
+            if m.retrying[dbname] != conf || m.confs[dbname] != conf {
+                m.mx.Unlock()
+                return
+            }
+            if _, ok := m.storage[dbname]; ok {
+                delete(m.retrying, dbname)
+                m.mx.Unlock()
+                return
+            }
+            err := m.connectAndStore(dbname, conf)
+            if err == nil {
+                delete(m.retrying, dbname)
+            }
+            m.mx.Unlock()
+
+            if err == nil {
+                return
+            }
+            m.log.Warn("可选db重试连接失败", F("dbname", dbname), F("dbtype", conf.dbtype), F("error", err.Error()))
+        }
+    }()
+}
+
+// 获取实例失败时的错误, 可选db还未连接成功时错误的Cause为ErrDBNotAvailable
+func (m *DBFactory) instanceNotFoundError(dbname string) error {
+    dbname = strings.ToLower(dbname)
+
+    m.mx.RLock()
+    conf, ok := m.confs[dbname]
+    lastErr := m.lastErrors[dbname]
+    m.mx.RUnlock()
+
+    if ok && conf.optional {
+        if lastErr != nil {
+            return zerrors.WrapSimplef(ErrDBNotAvailable, "<%s>未连接, 最后一次错误: %s", dbname, lastErr)
+        }
+        return zerrors.WrapSimplef(ErrDBNotAvailable, "<%s>未连接", dbname)
+    }
+    return zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+}
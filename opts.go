@@ -8,6 +8,13 @@
 
 package zdbfactory
 
+import (
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "go.opentelemetry.io/otel/trace"
+)
+
 type Options func(factory *DBFactory)
 
 // 收到进程退出信号自动关闭所有db
@@ -16,3 +23,115 @@ func WithAutoCloseDB() Options {
         factory.autoClose = true
     }
 }
+
+// 启用prometheus指标, 指标会注册到reg中
+//
+// 包括每个db的连接次数, 连接失败次数, 连接耗时, 当前实例数, 重连次数, 关闭失败次数,
+// 未释放的句柄数, 泄漏的句柄数, 等待句柄释放超时的次数,
+// 以及在每次抓取时从redis, mysql, ssdb实例中读取的连接池状态
+func WithMetrics(reg prometheus.Registerer) Options {
+    return func(factory *DBFactory) {
+        factory.metrics = newFactoryMetrics(reg, factory)
+    }
+}
+
+// 启用链路追踪, 工厂创建实例时会为实例装配追踪
+//
+// redis使用命令处理包装, mysql使用gorm回调, es使用http transport, kafka同步生产者使用包装器, mongo使用命令监视器
+// 每个span都带有db.system, dbname和语句属性
+//
+// span的父context: mysql来自GormWithContext, es和mongo来自请求的context, kafka来自消息头, redis没有父context
+func WithTracing(tp trace.TracerProvider) Options {
+    return func(factory *DBFactory) {
+        factory.plugins = append(factory.plugins, newTracingPlugin(tp))
+    }
+}
+
+// 设置日志记录器, 工厂会通过它报告配置加载, 连接, 重连, 关闭失败等事件, 配置中的敏感字段会被隐藏
+func WithLogger(log ILogger) Options {
+    return func(factory *DBFactory) {
+        if log == nil {
+            log = nopLogger{}
+        }
+        factory.log = log
+    }
+}
+
+// 注册钩子
+func WithHook(event HookEvent, fn HookFunc) Options {
+    return func(factory *DBFactory) {
+        factory.hooks[event] = append(factory.hooks[event], fn)
+    }
+}
+
+// 注册密钥提供者, 内置了env和file两个提供者
+func WithSecretProvider(name string, provider ISecretProvider) Options {
+    return func(factory *DBFactory) {
+        factory.setSecretProvider(name, provider)
+    }
+}
+
+// 设置实例被替换(如轮换凭证)后等待多久再关闭旧实例
+func WithDrainTime(d time.Duration) Options {
+    return func(factory *DBFactory) {
+        factory.drainTime = d
+    }
+}
+
+// 设置关闭实例前等待句柄释放的最长时间, 超时后会强制关闭
+func WithReleaseTimeout(d time.Duration) Options {
+    return func(factory *DBFactory) {
+        factory.releaseTimeout = d
+    }
+}
+
+// 添加解密密钥, 配置中 enc:v1:密钥id:密文 格式的值会在加载时用对应的密钥解密
+func WithCipherKey(keyID string, key []byte) Options {
+    return func(factory *DBFactory) {
+        factory.cipherKeys[keyID] = key
+    }
+}
+
+// 设置分片key的前缀, 默认为DBPrefix, 为空时节点下所有带有dbtype字段的表都是分片
+//
+// 设置了WithConfigSection时默认不使用前缀, 用这个选项设置后section中的key也需要带有前缀
+func WithDBPrefix(prefix string) Options {
+    return func(factory *DBFactory) {
+        factory.layout.prefix = prefix
+        factory.layout.prefixSet = true
+    }
+}
+
+// 设置分片所在的节点, 用.分隔, 如 database 表示从 [database.xxx] 中查找分片
+//
+// 节点下的key默认不需要前缀, 如 [database.main] 是分片main, 除非同时用WithDBPrefix设置了前缀.
+// 节点下key为db类型, 没有dbtype字段并且只包含表的表是一个分组, 如 [database.redis.main] 表示类型为redis的分片main.
+// 节点存在但没有找到任何分片时会记录警告日志
+func WithConfigSection(section string) Options {
+    return func(factory *DBFactory) {
+        factory.layout.section = section
+    }
+}
+
+// 设置profile, 如 dev 表示还会从 [dev.zdb_xxx] 中查找分片, 它会继承顶层同名分片的字段并覆盖
+//
+// 没有设置时使用环境变量ProfileEnv的值
+func WithProfile(profile string) Options {
+    return func(factory *DBFactory) {
+        factory.layout.profile = profile
+    }
+}
+
+// 设置可选db连接失败后在后台重试的间隔, 默认为DefaultOptionalRetryInterval
+func WithOptionalRetryInterval(d time.Duration) Options {
+    return func(factory *DBFactory) {
+        factory.optionalRetry = d
+    }
+}
+
+// 设置ConnectAllDB连接失败时的重试策略, 分片中的connect_retry会覆盖它
+func WithRetryPolicy(policy *RetryPolicy) Options {
+    return func(factory *DBFactory) {
+        factory.retryPolicy = policy
+    }
+}
@@ -0,0 +1,179 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/16
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/Shopify/sarama"
+    "github.com/go-redis/redis"
+    "github.com/jinzhu/gorm"
+    "github.com/zlyuancn/zerrors"
+    "golang.org/x/time/rate"
+)
+
+// 分片中的限流配置, 它是一个表, 字段见RateLimitConfig, 存在时启用限流
+//
+// 超过速率的请求会等待而不是失败, 支持redis, mysql, es和kafka生产者
+//
+// mysql(通过GormWithContext)和es使用调用者的context等待, 等待超过MaxWait或context结束时请求返回错误
+const RateLimitField = "rate_limit"
+
+// 限流等待的默认最长时间
+const DefaultRateLimitMaxWait = time.Second
+
+// 限流配置
+type RateLimitConfig struct {
+    Rate    float64  // 每秒允许的请求数, 必须大于0
+    Burst   int      // 允许突发的请求数, 默认为Rate向上取整
+    MaxWait Duration // 请求最多等待多久(毫秒), 默认为1秒, 调用者的context有更早的截止时间时以context为准
+}
+
+// 解析分片的rate_limit, 不存在时返回nil
+func parseRateLimit(dbname string, dbtype DBType, shard map[string]interface{}) (*rateLimiter, error) {
+    v, ok := shardField(shard, RateLimitField)
+    if !ok {
+        return nil, nil
+    }
+    if !wrappableDBTypes[dbtype] {
+        return nil, zerrors.NewSimplef("<%s>的db类型<%s>不支持%s", dbname, dbtype, RateLimitField)
+    }
+    table, ok := v.(map[string]interface{})
+    if !ok {
+        return nil, zerrors.NewSimplef("<%s>的%s必须为表", dbname, RateLimitField)
+    }
+
+    conf := new(RateLimitConfig)
+    if err := decodeShard(table, conf); err != nil {
+        return nil, zerrors.WrapSimplef(err, "<%s>的%s解析失败", dbname, RateLimitField)
+    }
+    if conf.Rate <= 0 {
+        return nil, zerrors.NewSimplef("<%s>的%s中rate必须大于0", dbname, RateLimitField)
+    }
+    if conf.Burst <= 0 {
+        conf.Burst = int(conf.Rate)
+        if float64(conf.Burst) < conf.Rate {
+            conf.Burst++
+        }
+    }
+    maxWait := conf.MaxWait.Duration()
+    if maxWait <= 0 {
+        maxWait = DefaultRateLimitMaxWait
+    }
+    return &rateLimiter{
+        dbname:  dbname,
+        limiter: rate.NewLimiter(rate.Limit(conf.Rate), conf.Burst),
+        maxWait: maxWait,
+    }, nil
+}
+
+// 限流器, 同一个db的所有实例共用一个限流器
+type rateLimiter struct {
+    dbname  string
+    limiter *rate.Limiter
+    maxWait time.Duration
+}
+
+// 等待n个请求的名额, 最多等待maxWait, 超时或ctx结束时返回错误
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+    ctx, cancel := context.WithTimeout(ctx, l.maxWait)
+    defer cancel()
+    if err := l.limiter.WaitN(ctx, n); err != nil {
+        return zerrors.WrapSimplef(err, "<%s>限流等待失败", l.dbname)
+    }
+    return nil
+}
+
+// 限流插件, 每个db的实例有自己的插件
+type rateLimitPlugin struct {
+    limiter *rateLimiter
+}
+
+var (
+    _ redisLimiterPlugin = (*rateLimitPlugin)(nil)
+    _ gormPlugin         = (*rateLimitPlugin)(nil)
+    _ httpPlugin         = (*rateLimitPlugin)(nil)
+    _ kafkaPlugin        = (*rateLimitPlugin)(nil)
+)
+
+func (m *rateLimitPlugin) redisLimiter(info *connectInfo) redis.Limiter {
+    return redisRateLimiter{m.limiter}
+}
+
+// 将限流器适配为redis.Limiter, 超过速率时等待, go-redis v6的命令不携带context, 最多等待MaxWait
+type redisRateLimiter struct {
+    limiter *rateLimiter
+}
+
+func (m redisRateLimiter) Allow() error {
+    return m.limiter.wait(context.Background(), 1)
+}
+
+func (m redisRateLimiter) ReportResult(result error) {}
+
+func (m *rateLimitPlugin) registerGorm(info *connectInfo, db *gorm.DB) {
+    before := func(scope *gorm.Scope) {
+        if err := m.limiter.wait(gormContext(scope), 1); err != nil {
+            scope.Err(err)
+        }
+    }
+
+    cb := db.Callback()
+    cb.Create().Before("gorm:create").Register("zdb:rate_limit_create", before)
+    cb.Update().Before("gorm:update").Register("zdb:rate_limit_update", before)
+    cb.Delete().Before("gorm:delete").Register("zdb:rate_limit_delete", before)
+    cb.Query().Before("gorm:query").Register("zdb:rate_limit_query", before)
+    cb.RowQuery().Before("gorm:row_query").Register("zdb:rate_limit_row_query", before)
+}
+
+func (m *rateLimitPlugin) wrapTransport(info *connectInfo, rt http.RoundTripper) http.RoundTripper {
+    return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+        if err := m.limiter.wait(req.Context(), 1); err != nil {
+            return nil, err
+        }
+        return rt.RoundTrip(req)
+    })
+}
+
+func (m *rateLimitPlugin) wrapSyncProducer(info *connectInfo, p sarama.SyncProducer) sarama.SyncProducer {
+    return &rateLimitSyncProducer{SyncProducer: p, limiter: m.limiter}
+}
+
+// 异步生产者无法向调用者返回限流错误, 消息在转交给原生产者前等待, 不受MaxWait限制, Input会因此产生背压
+func (m *rateLimitPlugin) wrapAsyncProducer(info *connectInfo, p sarama.AsyncProducer) sarama.AsyncProducer {
+    return newHookedAsyncProducer(p, func(msg *sarama.ProducerMessage) {
+        _ = m.limiter.limiter.Wait(context.Background())
+    })
+}
+
+// 限流的同步生产者, 批量发送时每条消息占用一个请求, 最多占用Burst个, 最多等待MaxWait
+type rateLimitSyncProducer struct {
+    sarama.SyncProducer
+    limiter *rateLimiter
+}
+
+func (m *rateLimitSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+    if err = m.limiter.wait(context.Background(), 1); err != nil {
+        return
+    }
+    return m.SyncProducer.SendMessage(msg)
+}
+
+func (m *rateLimitSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+    n := len(msgs)
+    if burst := m.limiter.limiter.Burst(); n > burst {
+        n = burst
+    }
+    if err := m.limiter.wait(context.Background(), n); err != nil {
+        return err
+    }
+    return m.SyncProducer.SendMessages(msgs)
+}
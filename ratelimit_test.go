@@ -0,0 +1,301 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/16
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "reflect"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/jinzhu/gorm"
+    "golang.org/x/time/rate"
+)
+
+// 每秒10个请求, 不允许突发的限流器, 每个请求需要等待100毫秒
+func newTestRateLimiter(maxWait time.Duration) *rateLimiter {
+    return &rateLimiter{dbname: "db", limiter: rate.NewLimiter(10, 1), maxWait: maxWait}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+    tests := []struct {
+        name       string
+        maxWait    time.Duration
+        ctx        func() (context.Context, context.CancelFunc)
+        wantErr    bool
+        minElapsed time.Duration
+        maxElapsed time.Duration
+    }{
+        {
+            name:       "waits for a token",
+            maxWait:    time.Second,
+            minElapsed: time.Millisecond * 50,
+            maxElapsed: time.Millisecond * 500,
+        },
+        {
+            name:       "rejects when the wait exceeds MaxWait",
+            maxWait:    time.Millisecond * 20,
+            wantErr:    true,
+            maxElapsed: time.Millisecond * 50,
+        },
+        {
+            name:    "rejects when the wait exceeds the caller deadline",
+            maxWait: time.Second,
+            ctx: func() (context.Context, context.CancelFunc) {
+                return context.WithTimeout(context.Background(), time.Millisecond*20)
+            },
+            wantErr:    true,
+            maxElapsed: time.Millisecond * 50,
+        },
+        {
+            name:    "stops when the caller context is cancelled",
+            maxWait: time.Second,
+            ctx: func() (context.Context, context.CancelFunc) {
+                ctx, cancel := context.WithCancel(context.Background())
+                cancel()
+                return ctx, cancel
+            },
+            wantErr:    true,
+            maxElapsed: time.Millisecond * 50,
+        },
+    }
+    for _, tt := range tests {
+        l := newTestRateLimiter(tt.maxWait)
+        if err := l.wait(context.Background(), 1); err != nil {
+            t.Fatalf("%s: first wait: %v", tt.name, err)
+        }
+
+        ctx, cancel := context.Background(), context.CancelFunc(func() {})
+        if tt.ctx != nil {
+            ctx, cancel = tt.ctx()
+        }
+        start := time.Now()
+        err := l.wait(ctx, 1)
+        elapsed := time.Since(start)
+        cancel()
+
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+        }
+        if err != nil && !strings.Contains(err.Error(), "<db>限流等待失败") {
+            t.Errorf("%s: err = %q, want a rate limit error", tt.name, err)
+        }
+        if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+            t.Errorf("%s: waited %v, want between %v and %v", tt.name, elapsed, tt.minElapsed, tt.maxElapsed)
+        }
+    }
+}
+
+// 记录执行次数的SQLCommon, 所有语句都返回错误
+type countingSQL struct {
+    execs int32
+}
+
+var errFakeSQL = errors.New("fake sql")
+
+func (c *countingSQL) Exec(query string, args ...interface{}) (sql.Result, error) {
+    atomic.AddInt32(&c.execs, 1)
+    return nil, errFakeSQL
+}
+
+func (c *countingSQL) Prepare(query string) (*sql.Stmt, error) {
+    return nil, errFakeSQL
+}
+
+func (c *countingSQL) Query(query string, args ...interface{}) (*sql.Rows, error) {
+    return nil, errFakeSQL
+}
+
+func (c *countingSQL) QueryRow(query string, args ...interface{}) *sql.Row {
+    return nil
+}
+
+type rateLimitedModel struct {
+    ID int
+}
+
+func TestRateLimitCallerContext(t *testing.T) {
+    cancelled, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    tests := []struct {
+        name    string
+        ctx     context.Context // 为nil时不设置context
+        wantErr string
+        wantRun bool // 请求是否被执行
+    }{
+        {name: "no context waits", wantErr: errFakeSQL.Error(), wantRun: true},
+        {name: "live context waits", ctx: context.Background(), wantErr: errFakeSQL.Error(), wantRun: true},
+        {name: "cancelled context", ctx: cancelled, wantErr: "限流等待失败"},
+    }
+
+    t.Run("gorm", func(t *testing.T) {
+        for _, tt := range tests {
+            conn := new(countingSQL)
+            db, err := gorm.Open("mysql", conn)
+            if err != nil {
+                t.Fatal(err)
+            }
+            db.SetLogger(log.New(ioutil.Discard, "", 0))
+            plugin := &rateLimitPlugin{limiter: newTestRateLimiter(time.Second)}
+            plugin.registerGorm(&connectInfo{dbname: "db", dbtype: Mysql}, db)
+            _ = plugin.limiter.wait(context.Background(), 1) // 用掉突发的名额
+
+            if tt.ctx != nil {
+                db = GormWithContext(db, tt.ctx)
+            }
+            err = db.Create(&rateLimitedModel{ID: 1}).Error
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Errorf("%s: err = %v, want it to contain %q", tt.name, err, tt.wantErr)
+            }
+            if ran := atomic.LoadInt32(&conn.execs) > 0; ran != tt.wantRun {
+                t.Errorf("%s: executed = %v, want %v", tt.name, ran, tt.wantRun)
+            }
+        }
+    })
+
+    t.Run("http", func(t *testing.T) {
+        for _, tt := range tests {
+            var runs int32
+            plugin := &rateLimitPlugin{limiter: newTestRateLimiter(time.Second)}
+            rt := plugin.wrapTransport(&connectInfo{dbname: "db", dbtype: ESv7}, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+                atomic.AddInt32(&runs, 1)
+                return nil, errFakeSQL
+            }))
+            _ = plugin.limiter.wait(context.Background(), 1)
+
+            req, _ := http.NewRequest(http.MethodGet, "http://es:9200/index/_search", nil)
+            if tt.ctx != nil {
+                req = req.WithContext(tt.ctx)
+            }
+            _, err := rt.RoundTrip(req)
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Errorf("%s: err = %v, want it to contain %q", tt.name, err, tt.wantErr)
+            }
+            if ran := atomic.LoadInt32(&runs) > 0; ran != tt.wantRun {
+                t.Errorf("%s: executed = %v, want %v", tt.name, ran, tt.wantRun)
+            }
+        }
+    })
+}
+
+// 记录调用的redis限制器
+type recordingLimiter struct {
+    name  string
+    err   error // Allow返回的错误
+    calls *[]string
+}
+
+func (l recordingLimiter) Allow() error {
+    *l.calls = append(*l.calls, l.name+".allow")
+    return l.err
+}
+
+func (l recordingLimiter) ReportResult(result error) {
+    *l.calls = append(*l.calls, l.name+".report("+errString(result)+")")
+}
+
+func errString(err error) string {
+    if err == nil {
+        return "nil"
+    }
+    return err.Error()
+}
+
+func TestMultiRedisLimiter(t *testing.T) {
+    rejected := errors.New("rejected")
+    cmdErr := errors.New("cmd")
+
+    tests := []struct {
+        name      string
+        errs      []error // 每个限制器Allow返回的错误
+        result    error   // Allow成功后报告的结果
+        wantErr   error
+        wantCalls []string
+    }{
+        {
+            name:      "all allow",
+            errs:      []error{nil, nil},
+            result:    cmdErr,
+            wantCalls: []string{"a.allow", "b.allow", "a.report(cmd)", "b.report(cmd)"},
+        },
+        {
+            name:      "first rejects",
+            errs:      []error{rejected, nil},
+            wantErr:   rejected,
+            wantCalls: []string{"a.allow"},
+        },
+        {
+            name:      "second rejects and the first gets nil",
+            errs:      []error{nil, rejected, nil},
+            wantErr:   rejected,
+            wantCalls: []string{"a.allow", "b.allow", "a.report(nil)"},
+        },
+    }
+    for _, tt := range tests {
+        var calls []string
+        var m multiRedisLimiter
+        for i, err := range tt.errs {
+            m = append(m, recordingLimiter{name: string(rune('a' + i)), err: err, calls: &calls})
+        }
+
+        err := m.Allow()
+        if err != tt.wantErr {
+            t.Errorf("%s: Allow() = %v, want %v", tt.name, err, tt.wantErr)
+        }
+        if err == nil {
+            m.ReportResult(tt.result)
+        }
+        if !reflect.DeepEqual(calls, tt.wantCalls) {
+            t.Errorf("%s: calls = %v, want %v", tt.name, calls, tt.wantCalls)
+        }
+    }
+}
+
+func TestRateLimitBeforeBreaker(t *testing.T) {
+    conf := &CircuitBreakerConfig{FailureRatio: 0.5, Window: 10000, MinRequests: 1, OpenDuration: 10000}
+    breaker := newCircuitBreaker("db", conf, nopLogger{})
+    breaker.windowStart = time.Now()
+
+    // 熔断器打开后经过OpenDuration, 下一个请求是半开时的探测请求
+    _ = breaker.allow()
+    breaker.report(true)
+    breaker.openedAt = breaker.openedAt.Add(-breaker.openDuration)
+
+    limiter := newTestRateLimiter(time.Millisecond * 20)
+    _ = limiter.wait(context.Background(), 1)
+    m := multiRedisLimiter{redisRateLimiter{limiter}, redisBreakerLimiter{breaker}}
+
+    // 被限流拒绝的请求不会占用探测名额
+    if err := m.Allow(); err == nil || IsCircuitOpen(err) {
+        t.Fatalf("Allow() = %v, want a rate limit error", err)
+    }
+    if breaker.state != CircuitOpen {
+        t.Fatalf("breaker state = %s after a rate limited request, want %s", breaker.state, CircuitOpen)
+    }
+
+    time.Sleep(time.Millisecond * 100)
+    if err := m.Allow(); err != nil {
+        t.Fatalf("probe Allow() = %v", err)
+    }
+    if breaker.state != CircuitHalfOpen {
+        t.Errorf("breaker state = %s, want %s", breaker.state, CircuitHalfOpen)
+    }
+    m.ReportResult(nil)
+    if breaker.state != CircuitClosed {
+        t.Errorf("breaker state = %s after a successful probe, want %s", breaker.state, CircuitClosed)
+    }
+}
@@ -0,0 +1,126 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/18
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "net/url"
+    "reflect"
+    "strings"
+)
+
+// 敏感值被替换后的内容
+const RedactedValue = "******"
+
+// 字段名包含这些词(不区分大小写)时被视为敏感字段
+var sensitiveFieldWords = []string{"password", "passwd", "secret", "token", "key"}
+
+// 判断字段名是否为敏感字段
+func isSensitiveField(name string) bool {
+    name = strings.ToLower(name)
+    for _, w := range sensitiveFieldWords {
+        if strings.Contains(name, w) {
+            return true
+        }
+    }
+    return false
+}
+
+// 隐藏url中的密码, 不是url或没有密码时原样返回
+func redactURL(s string) string {
+    if !strings.Contains(s, "://") || !strings.Contains(s, "@") {
+        return s
+    }
+    u, err := url.Parse(s)
+    if err != nil || u.User == nil {
+        return s
+    }
+    if _, ok := u.User.Password(); !ok {
+        return s
+    }
+    u.User = url.User(u.User.Username())
+    out := u.String()
+    i := strings.Index(out, "@")
+    return out[:i] + ":" + RedactedValue + out[i:]
+}
+
+// 将配置结构转为map, 敏感字段的值会被隐藏
+//
+// secretKeys 为额外需要隐藏的字段名(小写), 如从密钥提供者中解析出来的字段
+func redactConfig(config interface{}, secretKeys ...string) map[string]interface{} {
+    v := reflect.ValueOf(config)
+    for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+        if v.IsNil() {
+            return nil
+        }
+        v = v.Elem()
+    }
+    if v.Kind() != reflect.Struct {
+        return nil
+    }
+
+    out := make(map[string]interface{}, v.NumField())
+    t := v.Type()
+    for i := 0; i < v.NumField(); i++ {
+        field := t.Field(i)
+        if field.PkgPath != "" {
+            continue
+        }
+        if containsString(secretKeys, strings.ToLower(field.Name)) {
+            out[field.Name] = RedactedValue
+            continue
+        }
+        out[field.Name] = redactValue(field.Name, v.Field(i))
+    }
+    return out
+}
+
+func redactValue(name string, v reflect.Value) interface{} {
+    switch v.Kind() {
+    case reflect.String:
+        if v.Len() > 0 && isSensitiveField(name) {
+            return RedactedValue
+        }
+        return redactURL(v.String())
+    case reflect.Slice:
+        if v.Type().Elem().Kind() != reflect.String {
+            return v.Interface()
+        }
+        out := make([]string, v.Len())
+        for i := range out {
+            out[i], _ = redactValue(name, v.Index(i)).(string)
+        }
+        return out
+    case reflect.Map:
+        // 如mysql的Params和mongo的Options, 按key判断是否敏感
+        if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String || v.IsNil() {
+            return v.Interface()
+        }
+        out := make(map[string]string, v.Len())
+        iter := v.MapRange()
+        for iter.Next() {
+            key := iter.Key().String()
+            out[key], _ = redactValue(key, iter.Value()).(string)
+        }
+        return out
+    case reflect.Struct, reflect.Ptr:
+        if m := redactConfig(v.Interface()); m != nil {
+            return m
+        }
+    }
+    return v.Interface()
+}
+
+func containsString(ss []string, s string) bool {
+    for _, v := range ss {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
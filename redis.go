@@ -9,7 +9,8 @@
 package zdbfactory
 
 import (
-    "time"
+    "context"
+    "crypto/tls"
 
     "github.com/go-redis/redis"
     "github.com/zlyuancn/zerrors"
@@ -18,6 +19,8 @@ import (
 type redisFactory int
 
 var _ IDBFactory = (*redisFactory)(nil)
+var _ iDBFactoryWithPlugins = (*redisFactory)(nil)
+var _ IDBPinger = (*redisFactory)(nil)
 
 type RedisConfig struct {
     Address      []string // [host1:port1, host2:port2]
@@ -25,17 +28,26 @@ type RedisConfig struct {
     DB           int
     IsCluster    bool
     PoolSize     int
-    ReadTimeout  int64 // 超时(毫秒
-    WriteTimeout int64 // 超时(毫秒
-    DialTimeout  int64 // 超时(毫秒
-    Ping         bool  // 开始连接时是否ping确认连接情况
+    ReadTimeout  Duration // 超时(毫秒
+    WriteTimeout Duration // 超时(毫秒
+    DialTimeout  Duration // 超时(毫秒
+    TLS          bool     // 是否启用tls
+    Ping         bool     // 开始连接时是否ping确认连接情况
 }
 
 func (redisFactory) MakeEmptyConfig() interface{} {
     return new(RedisConfig)
 }
 
-func (redisFactory) Connect(config interface{}) (interface{}, error) {
+func (m redisFactory) Connect(config interface{}) (interface{}, error) {
+    return m.connect(config, nil)
+}
+
+func (m redisFactory) connectWithPlugins(info *connectInfo, plugins []instancePlugin) (interface{}, error) {
+    return m.connect(info.config, pluginRedisLimiter(info, plugins))
+}
+
+func (redisFactory) connect(config interface{}, limiter redis.Limiter) (interface{}, error) {
     var conf *RedisConfig
     switch c := config.(type) {
     case *RedisConfig:
@@ -46,29 +58,48 @@ func (redisFactory) Connect(config interface{}) (interface{}, error) {
         return nil, zerrors.NewSimple("非*RedisConfig结构")
     }
 
+    var tlsConfig *tls.Config
+    if conf.TLS {
+        tlsConfig = &tls.Config{}
+    }
+
+    var onNewNode func(*redis.Client)
+    if limiter != nil {
+        onNewNode = func(node *redis.Client) {
+            node.SetLimiter(limiter)
+        }
+    }
+
     var c redis.UniversalClient
     if conf.IsCluster {
         c = redis.NewClusterClient(&redis.ClusterOptions{
             Addrs:        conf.Address,
             Password:     conf.Password,
             PoolSize:     conf.PoolSize,
-            ReadTimeout:  time.Duration(conf.ReadTimeout * 1e6),
-            WriteTimeout: time.Duration(conf.WriteTimeout * 1e6),
-            DialTimeout:  time.Duration(conf.DialTimeout * 1e6),
+            ReadTimeout:  conf.ReadTimeout.Duration(),
+            WriteTimeout: conf.WriteTimeout.Duration(),
+            DialTimeout:  conf.DialTimeout.Duration(),
+            TLSConfig:    tlsConfig,
+            OnNewNode:    onNewNode,
         })
     } else {
         if len(conf.Address) < 1 {
             return nil, zerrors.NewSimple("请检查redis配置的address")
         }
-        c = redis.NewClient(&redis.Options{
+        client := redis.NewClient(&redis.Options{
             Addr:         conf.Address[0],
             Password:     conf.Password,
             DB:           conf.DB,
             PoolSize:     conf.PoolSize,
-            ReadTimeout:  time.Duration(conf.ReadTimeout * 1e6),
-            WriteTimeout: time.Duration(conf.WriteTimeout * 1e6),
-            DialTimeout:  time.Duration(conf.DialTimeout * 1e6),
+            ReadTimeout:  conf.ReadTimeout.Duration(),
+            WriteTimeout: conf.WriteTimeout.Duration(),
+            DialTimeout:  conf.DialTimeout.Duration(),
+            TLSConfig:    tlsConfig,
         })
+        if limiter != nil {
+            client.SetLimiter(limiter)
+        }
+        c = client
     }
 
     if conf.Ping {
@@ -78,6 +109,17 @@ func (redisFactory) Connect(config interface{}) (interface{}, error) {
     }
     return c, nil
 }
+func (redisFactory) Ping(ctx context.Context, dbinstance interface{}) error {
+    c, ok := dbinstance.(redis.UniversalClient)
+    if !ok {
+        return zerrors.NewSimple("非redis.UniversalClient结构")
+    }
+
+    return pingWithContext(ctx, func() error {
+        return c.Ping().Err()
+    })
+}
+
 func (redisFactory) Close(dbinstance interface{}) error {
     c, ok := dbinstance.(redis.UniversalClient)
     if !ok {
@@ -96,7 +138,7 @@ func AddRedisConfig(dbname string, conf *RedisConfig) {
 func GetRedis(dbname string) (redis.UniversalClient, error) {
     a := defaultDBFactory.GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != Redis {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.dbtype)
@@ -105,6 +147,19 @@ func GetRedis(dbname string) (redis.UniversalClient, error) {
     return a.Instance().(redis.UniversalClient), nil
 }
 
+// 获取redis分片组中key对应的redisdb实例
+func GetRedisShard(group, key string) (redis.UniversalClient, error) {
+    a, err := defaultDBFactory.GetShard(group, key)
+    if err != nil {
+        return nil, err
+    }
+    if a.Type() != Redis {
+        return nil, zerrors.NewSimplef("分片组<%s>的成员是<%v>类型", group, a.dbtype)
+    }
+
+    return a.Instance().(redis.UniversalClient), nil
+}
+
 // 获取redisdb实例, 该实例如果不是redis类型会panic
 func MustGetRedis(dbname string) redis.UniversalClient {
     c, err := GetRedis(dbname)
@@ -0,0 +1,127 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/12
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "math/rand"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 分片中的连接重试策略, 它是一个表, 字段见RetryPolicy, 会覆盖WithRetryPolicy设置的全局策略
+const ConnectRetryField = "connect_retry"
+
+// 没有设置InitialBackoff时第一次重试前的等待时间
+const DefaultRetryInitialBackoff = time.Millisecond * 500
+
+// ConnectAllDB连接失败时的重试策略
+type RetryPolicy struct {
+    MaxAttempts    int      // 最多尝试次数, 包括第一次连接, 小于2时不会重试
+    InitialBackoff Duration // 第一次重试前的等待时间, 之后每次翻倍
+    MaxBackoff     Duration // 最大等待时间, 为0时不限制
+    Jitter         float64  // 等待时间随机增减的比例, 取值范围为0到1
+}
+
+// 第attempt次连接失败后的等待时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+    backoff := p.InitialBackoff.Duration()
+    if backoff <= 0 {
+        backoff = DefaultRetryInitialBackoff
+    }
+    max := p.MaxBackoff.Duration()
+    for i := 1; i < attempt && (max <= 0 || backoff < max); i++ {
+        backoff *= 2
+    }
+    if max > 0 && backoff > max {
+        backoff = max
+    }
+
+    if p.Jitter > 0 {
+        jitter := p.Jitter
+        if jitter > 1 {
+            jitter = 1
+        }
+        backoff += time.Duration(float64(backoff) * jitter * (rand.Float64()*2 - 1))
+    }
+    return backoff
+}
+
+// 解析分片的connect_retry, 不存在时返回nil
+func parseRetryPolicy(dbname string, shard map[string]interface{}) (*RetryPolicy, error) {
+    v, ok := shardField(shard, ConnectRetryField)
+    if !ok {
+        return nil, nil
+    }
+    table, ok := v.(map[string]interface{})
+    if !ok {
+        return nil, zerrors.NewSimplef("<%s>的%s必须为表", dbname, ConnectRetryField)
+    }
+
+    policy := new(RetryPolicy)
+    if err := decodeShard(table, policy); err != nil {
+        return nil, zerrors.WrapSimplef(err, "<%s>的%s解析失败", dbname, ConnectRetryField)
+    }
+    return policy, nil
+}
+
+// 按重试策略连接db并保存实例, 没有重试策略时只会连接一次
+//
+// 每次重试前会触发ConnectRetry钩子, 钩子返回错误时停止重试. ctx结束或等待时间会超过ctx的截止时间时停止重试.
+// 调用者需要持有锁, 连接和等待重试期间会释放锁
+func (m *DBFactory) connectWithRetry(ctx context.Context, dbname string, conf *dbConfig) error {
+    policy := conf.retry
+    if policy == nil {
+        policy = m.retryPolicy
+    }
+    attempts := 1
+    if policy != nil && policy.MaxAttempts > 1 {
+        attempts = policy.MaxAttempts
+    }
+
+    for attempt := 1; ; attempt++ {
+        err := m.connectAndStore(dbname, conf)
+        if err == nil || attempt >= attempts {
+            return err
+        }
+
+        backoff := policy.backoff(attempt)
+        if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+            return zerrors.WrapSimple(err, "等待重试会超过context的截止时间")
+        }
+
+        m.mx.Unlock()
+
+        hctx := &HookContext{Event: ConnectRetry, DBName: dbname, DBType: conf.dbtype, Config: conf.config, Err: err, Attempt: attempt + 1}
+        if m.triggerHook(hctx) != nil {
+            m.mx.Lock()
+            return err
+        }
+        m.log.Warn("db连接失败, 等待重试", F("dbname", dbname), F("dbtype", conf.dbtype), F("attempt", attempt), F("max_attempts", attempts), F("backoff", backoff), F("error", err.Error()))
+
+        timer := time.NewTimer(backoff)
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            timer.Stop()
+        }
+        m.mx.Lock()
+
+        if ctx.Err() != nil {
+            return zerrors.WrapSimplef(err, "等待重试时context已结束(%s)", ctx.Err())
+        }
+        if m.confs[dbname] != conf {
+            return zerrors.NewSimplef("<%s>的配置在等待重试期间被修改", dbname)
+        }
+        if _, ok := m.storage[dbname]; ok {
+            return nil
+        }
+    }
+}
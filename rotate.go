@@ -0,0 +1,102 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/25
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "strings"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 默认的旧实例关闭等待时间
+const DefaultDrainTime = time.Second * 10
+
+// 重新解析dbname的密钥并在后台重建实例
+//
+// 新实例连接成功后才会替换旧实例, 旧实例会在等待一段时间后关闭, 让正在进行的操作完成
+// 连接失败时保留旧实例并返回错误. 只会影响这一个dbname, 不会影响其它实例
+//
+// 解析密钥和连接新实例时不持有工厂的锁, 只在替换实例时短暂持有, 期间其它实例可以正常获取
+func (m *DBFactory) RotateCredentials(dbname string) error {
+    dbname = strings.ToLower(dbname)
+
+    m.mx.RLock()
+    old, ok := m.confs[dbname]
+    m.mx.RUnlock()
+    if !ok {
+        return zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+    }
+    if old.raw == nil {
+        return zerrors.NewSimplef("<%s>不是从配置分片中加载的, 无法重新解析密钥", dbname)
+    }
+
+    conf, err := m.buildDBConfig(dbname, old.raw)
+    if err != nil {
+        return err
+    }
+
+    m.mx.Lock()
+    if m.confs[dbname] != old {
+        m.mx.Unlock()
+        return zerrors.NewSimplef("<%s>的配置在轮换期间被修改", dbname)
+    }
+
+    // 连接新实例时会释放锁, 重新加锁后确认配置没有被修改才会替换
+    oldInstance, event, err := m.swapDBConfig(dbname, conf, false)
+    m.mx.Unlock()
+    if err != nil {
+        return zerrors.WrapSimplef(err, "<%s>轮换凭证时连接失败", dbname)
+    }
+    _ = m.triggerHook(event)
+
+    m.log.Info("db凭证已轮换", F("dbname", dbname), F("dbtype", conf.dbtype))
+
+    if oldInstance != nil {
+        m.closeLater(dbname, oldInstance, m.drainTime)
+    }
+    return nil
+}
+
+// 替换db的配置, 已连接的db会用新配置连接新实例并替换旧实例, 不会关闭旧实例
+//
+// connect为true时未连接的db也会被连接. 连接失败时不会修改任何东西
+//
+// 返回被替换掉的旧实例和配置事件的钩子参数, 调用者需要在释放锁后触发钩子
+//
+// 调用者需要持有锁, 连接期间会释放锁, 连接期间配置被修改时返回错误
+func (m *DBFactory) swapDBConfig(dbname string, conf *dbConfig, connect bool) (*DBInstance, *HookContext, error) {
+    var oldInstance *DBInstance
+    if _, connected := m.storage[dbname]; connected || connect {
+        instance, err := m.connectDB(dbname, conf, m.confs[dbname])
+        if err != nil {
+            return nil, nil, err
+        }
+        oldInstance = m.storage[dbname]
+        m.storage[dbname] = newDBInstance(conf, instance)
+    }
+
+    _, replaced := m.confs[dbname]
+    m.confs[dbname] = conf
+
+    event := ConfigAdded
+    if replaced {
+        event = ConfigReplaced
+    }
+    return oldInstance, &HookContext{Event: event, DBName: dbname, DBType: conf.dbtype, Config: conf.config}, nil
+}
+
+// 在后台等待drain并且句柄全部释放后关闭已从storage中移除的实例, 等待句柄释放最多releaseTimeout
+func (m *DBFactory) closeLater(dbname string, instance *DBInstance, drain time.Duration) {
+    go func() {
+        time.Sleep(drain)
+        m.waitReleased(dbname, instance, time.Now().Add(m.releaseTimeout))
+        _ = m.closeDB(dbname, instance)
+    }()
+}
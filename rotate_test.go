@@ -0,0 +1,240 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/25
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+func TestSwapDBConfig(t *testing.T) {
+    tests := []struct {
+        name        string
+        existing    *fakeConfig // 为nil表示db不存在
+        connected   bool
+        connect     bool
+        conf        *fakeConfig
+        modify      bool // 连接期间修改配置
+        wantErr     string
+        wantEvent   HookEvent
+        wantOld     bool
+        wantStored  bool
+        wantNewConf bool // 配置是否被替换为conf
+    }{
+        {
+            name:        "add without connecting",
+            conf:        &fakeConfig{},
+            wantEvent:   ConfigAdded,
+            wantNewConf: true,
+        },
+        {
+            name:        "add and connect",
+            connect:     true,
+            conf:        &fakeConfig{},
+            wantEvent:   ConfigAdded,
+            wantStored:  true,
+            wantNewConf: true,
+        },
+        {
+            name:        "replace a not connected db",
+            existing:    &fakeConfig{},
+            conf:        &fakeConfig{},
+            wantEvent:   ConfigReplaced,
+            wantNewConf: true,
+        },
+        {
+            name:        "replace a connected db",
+            existing:    &fakeConfig{},
+            connected:   true,
+            conf:        &fakeConfig{},
+            wantEvent:   ConfigReplaced,
+            wantOld:     true,
+            wantStored:  true,
+            wantNewConf: true,
+        },
+        {
+            name:       "connect failure keeps the old instance",
+            existing:   &fakeConfig{},
+            connected:  true,
+            conf:       &fakeConfig{Fail: true},
+            wantErr:    "fake连接失败",
+            wantStored: true,
+        },
+        {
+            name:    "connect failure of a new db",
+            connect: true,
+            conf:    &fakeConfig{Fail: true},
+            wantErr: "fake连接失败",
+        },
+        {
+            name:       "config modified while connecting",
+            existing:   &fakeConfig{},
+            connected:  true,
+            conf:       &fakeConfig{},
+            modify:     true,
+            wantErr:    "在连接期间被修改",
+            wantStored: false, // 修改配置会移除旧实例
+        },
+    }
+    for _, tt := range tests {
+        m := New()
+        if tt.existing != nil {
+            m.AddDBConfig("a", fakeDB, tt.existing)
+            if tt.connected {
+                if err := m.ConnectAllDB(); err != nil {
+                    t.Fatalf("%s: ConnectAllDB: %v", tt.name, err)
+                }
+            }
+        }
+        var before *DBInstance
+        if tt.connected {
+            before = m.GetDBInstance("a")
+        }
+        if tt.modify {
+            m.AddHook(BeforeConnect, func(ctx *HookContext) error {
+                m.AddDBConfig("a", fakeDB, &fakeConfig{})
+                return nil
+            })
+        }
+
+        conf := &dbConfig{dbtype: fakeDB, config: tt.conf}
+        m.mx.Lock()
+        old, event, err := m.swapDBConfig("a", conf, tt.connect)
+        m.mx.Unlock()
+
+        if tt.wantErr != "" {
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Errorf("%s: err = %v, want it to contain %q", tt.name, err, tt.wantErr)
+            }
+            if event != nil || old != nil {
+                t.Errorf("%s: failed swap returned old = %v, event = %v", tt.name, old, event)
+            }
+        } else {
+            if err != nil {
+                t.Fatalf("%s: err = %v", tt.name, err)
+            }
+            if event.Event != tt.wantEvent || event.DBName != "a" || event.Config != tt.conf {
+                t.Errorf("%s: event = %+v, want %s", tt.name, event, tt.wantEvent)
+            }
+            if (old != nil) != tt.wantOld || (old != nil && old != before) {
+                t.Errorf("%s: old = %v, want the previous instance %v", tt.name, old, tt.wantOld)
+            }
+        }
+
+        current := m.GetDBInstance("a")
+        if (current != nil) != tt.wantStored {
+            t.Errorf("%s: stored = %v, want %v", tt.name, current != nil, tt.wantStored)
+        }
+        if tt.wantErr != "" && current != nil && current != before {
+            t.Errorf("%s: failed swap replaced the instance", tt.name)
+        }
+        if got := m.confs["a"] == conf; got != tt.wantNewConf {
+            t.Errorf("%s: config replaced = %v, want %v", tt.name, got, tt.wantNewConf)
+        }
+        if old != nil {
+            _ = m.closeDB("a", old)
+        }
+        m.CloseAllDb()
+    }
+}
+
+// 可以修改的密钥提供者
+type mapSecretProvider struct {
+    secrets map[string]string
+    mx      sync.Mutex
+}
+
+func (p *mapSecretProvider) set(ref, value string) {
+    p.mx.Lock()
+    p.secrets[ref] = value
+    p.mx.Unlock()
+}
+
+func (p *mapSecretProvider) GetSecret(ref string) (string, error) {
+    p.mx.Lock()
+    defer p.mx.Unlock()
+    if v, ok := p.secrets[ref]; ok {
+        return v, nil
+    }
+    return "", zerrors.NewSimplef("不存在的密钥<%s>", ref)
+}
+
+func TestRotateCredentials(t *testing.T) {
+    secrets := &mapSecretProvider{secrets: map[string]string{"pass": "v1"}}
+    m := New(WithSecretProvider("test", secrets), WithDrainTime(0))
+    m.AddDBConfig("plain", fakeDB, &fakeConfig{})
+    if err := m.addShard("a", map[string]interface{}{"dbtype": string(fakeDB), "password": "${test:pass}"}); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name     string
+        dbname   string
+        connect  bool
+        secret   string // 轮换前设置的密钥, 为空时删除密钥
+        wantErr  string
+        wantPass string
+    }{
+        {name: "not connected", dbname: "a", secret: "v2", wantPass: "v2"},
+        {name: "connected", dbname: "a", connect: true, secret: "v3", wantPass: "v3"},
+        {name: "secret failure keeps the config", dbname: "a", secret: "", wantErr: "test:pass", wantPass: "v3"},
+        {name: "not loaded from a shard", dbname: "plain", wantErr: "不是从配置分片中加载的"},
+        {name: "missing db", dbname: "nope", wantErr: "不存在的dbname<nope>"},
+    }
+    for _, tt := range tests {
+        if tt.connect {
+            if err := m.ConnectDBContext(context.Background(), "a"); err != nil {
+                t.Fatalf("%s: connect: %v", tt.name, err)
+            }
+        }
+        if tt.secret != "" {
+            secrets.set("pass", tt.secret)
+        } else {
+            secrets.mx.Lock()
+            delete(secrets.secrets, "pass")
+            secrets.mx.Unlock()
+        }
+
+        var old *fakeConn
+        if instance := m.GetDBInstance("a"); instance != nil {
+            old = instance.instance.(*fakeConn)
+        }
+
+        err := m.RotateCredentials(tt.dbname)
+        switch {
+        case tt.wantErr == "" && err != nil:
+            t.Errorf("%s: err = %v, want nil", tt.name, err)
+        case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+            t.Errorf("%s: err = %v, want it to contain %q", tt.name, err, tt.wantErr)
+        }
+        if tt.wantPass == "" {
+            continue
+        }
+
+        if got := m.confs["a"].config.(*fakeConfig).Password; got != tt.wantPass {
+            t.Errorf("%s: password = %q, want %q", tt.name, got, tt.wantPass)
+        }
+        if old == nil {
+            continue
+        }
+        current := fakeConnOf(t, m, "a")
+        if current.conf.Password != tt.wantPass {
+            t.Errorf("%s: instance password = %q, want %q", tt.name, current.conf.Password, tt.wantPass)
+        }
+        if tt.wantErr == "" && !waitFor(time.Second, old.isClosed) {
+            t.Errorf("%s: rotated instance is not closed", tt.name)
+        }
+    }
+    m.CloseAllDb()
+}
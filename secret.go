@@ -0,0 +1,261 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/22
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 密钥提供者, 根据引用获取密钥的值
+//
+// 在配置中使用 ${提供者名:引用} 来引用一个密钥, 如 ${env:REDIS_PASS}, ${file:/run/secrets/mysql}
+type ISecretProvider interface {
+    GetSecret(ref string) (string, error)
+}
+
+// 密钥引用的格式
+var secretRefRegexp = regexp.MustCompile(`\$\{(\w+):([^}]*)\}`)
+
+// 可以通知密钥变更的密钥提供者
+//
+// 注册到工厂时工厂会设置回调, 提供者在密钥变更时调用回调, 工厂会在后台重建使用了该密钥的实例
+type ISecretNotifier interface {
+    ISecretProvider
+    // 设置密钥变更的回调, ref为发生变更的引用
+    OnSecretChange(fn func(ref string))
+}
+
+// 从环境变量中获取密钥
+type EnvSecretProvider struct{}
+
+func (EnvSecretProvider) GetSecret(ref string) (string, error) {
+    v, ok := os.LookupEnv(ref)
+    if !ok {
+        return "", zerrors.NewSimplef("环境变量<%s>不存在", ref)
+    }
+    return v, nil
+}
+
+// 从文件中获取密钥, 文件末尾的换行会被去掉
+type FileSecretProvider struct{}
+
+func (FileSecretProvider) GetSecret(ref string) (string, error) {
+    bs, err := ioutil.ReadFile(ref)
+    if err != nil {
+        return "", zerrors.NewSimplef("无法读取文件<%s>", ref)
+    }
+    return strings.TrimRight(string(bs), "\r\n"), nil
+}
+
+// 注册密钥提供者, 重复的名字会被替换掉
+func (m *DBFactory) RegisterSecretProvider(name string, provider ISecretProvider) {
+    m.mx.Lock()
+    m.setSecretProvider(name, provider)
+    m.mx.Unlock()
+}
+
+func (m *DBFactory) setSecretProvider(name string, provider ISecretProvider) {
+    m.secretProviders[name] = provider
+    if n, ok := provider.(ISecretNotifier); ok {
+        n.OnSecretChange(func(ref string) {
+            m.secretChanged(name, ref)
+        })
+    }
+}
+
+func (m *DBFactory) getSecretProvider(name string) (ISecretProvider, bool) {
+    m.mx.RLock()
+    p, ok := m.secretProviders[name]
+    m.mx.RUnlock()
+    return p, ok
+}
+
+// 密钥变更后在后台重建使用了该密钥的实例
+func (m *DBFactory) secretChanged(name, ref string) {
+    key := name + ":" + ref
+
+    var dbnames []string
+    m.mx.RLock()
+    for dbname, conf := range m.confs {
+        if containsString(conf.secretRefs, key) {
+            dbnames = append(dbnames, dbname)
+        }
+    }
+    m.mx.RUnlock()
+
+    for _, dbname := range dbnames {
+        go func(dbname string) {
+            if err := m.RotateCredentials(dbname); err != nil {
+                m.log.Error("密钥变更后重建实例失败", F("dbname", dbname), F("error", err.Error()))
+            }
+        }(dbname)
+    }
+}
+
+// 密钥解析结果
+type resolvedShard struct {
+    shard      map[string]interface{}
+    secretKeys []string // 包含密钥的顶层key(小写)
+    secretRefs []string // 使用到的引用, 格式为 提供者名:引用
+
+    secretValues []string // 来自密钥的值, 用于隐藏错误信息中的密钥
+}
+
+// 解析分片中的密钥引用和加密值
+//
+// 错误信息中只会包含引用, 不会包含密钥的值
+func (m *DBFactory) resolveSecrets(dbname string, shard map[string]interface{}) (*resolvedShard, error) {
+    out := &resolvedShard{shard: make(map[string]interface{}, len(shard))}
+    for k, v := range shard {
+        rv, found, err := walkStrings(v, func(s string) (string, bool, error) {
+            if isEncryptedValue(s) {
+                plain, err := m.decryptValue(s)
+                return plain, true, err
+            }
+
+            rs, refs, err := m.resolveSecretString(s)
+            out.secretRefs = append(out.secretRefs, refs...)
+            return rs, len(refs) > 0, err
+        })
+        if err != nil {
+            return nil, zerrors.WrapSimplef(err, "<%s>的字段<%s>", dbname, k)
+        }
+        if found {
+            out.secretKeys = append(out.secretKeys, strings.ToLower(k))
+            out.secretValues = append(out.secretValues, secretValues(rv)...)
+        }
+        out.shard[k] = rv
+    }
+    return out, nil
+}
+
+// 值中所有需要隐藏的字符串, 非字符串的值转为字符串
+func secretValues(v interface{}) []string {
+    var out []string
+    switch vv := v.(type) {
+    case nil:
+    case string:
+        if vv != "" {
+            out = append(out, vv)
+        }
+    case []interface{}:
+        for _, item := range vv {
+            out = append(out, secretValues(item)...)
+        }
+    case []string:
+        for _, item := range vv {
+            out = append(out, secretValues(item)...)
+        }
+    case map[string]interface{}:
+        for _, item := range vv {
+            out = append(out, secretValues(item)...)
+        }
+    default:
+        out = append(out, fmt.Sprint(vv))
+    }
+    return out
+}
+
+// 隐藏错误信息中来自密钥的值, 如解码失败时mapstructure和ParseDuration的错误会包含字段的值
+//
+// 错误信息中包含密钥时返回只包含隐藏后信息的新错误
+func redactSecretError(err error, values []string) error {
+    // 先替换长的值, 避免短的值是长的值的一部分时只隐藏了一部分
+    sorted := append([]string(nil), values...)
+    sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+    msg := err.Error()
+    redacted := msg
+    for _, v := range sorted {
+        redacted = strings.Replace(redacted, v, RedactedValue, -1)
+    }
+    if redacted == msg {
+        return err
+    }
+    return zerrors.NewSimple(redacted)
+}
+
+// 遍历值中的所有字符串并用fn替换, fn返回的bool表示是否替换过
+func walkStrings(v interface{}, fn func(s string) (string, bool, error)) (interface{}, bool, error) {
+    switch vv := v.(type) {
+    case string:
+        return fn(vv)
+    case []interface{}:
+        out := make([]interface{}, len(vv))
+        found := false
+        for i, item := range vv {
+            rv, f, err := walkStrings(item, fn)
+            if err != nil {
+                return nil, false, err
+            }
+            out[i], found = rv, found || f
+        }
+        return out, found, nil
+    case []string:
+        out := make([]string, len(vv))
+        found := false
+        for i, item := range vv {
+            rv, f, err := fn(item)
+            if err != nil {
+                return nil, false, err
+            }
+            out[i], found = rv, found || f
+        }
+        return out, found, nil
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(vv))
+        found := false
+        for k, item := range vv {
+            rv, f, err := walkStrings(item, fn)
+            if err != nil {
+                return nil, false, err
+            }
+            out[k], found = rv, found || f
+        }
+        return out, found, nil
+    }
+    return v, false, nil
+}
+
+// 解析字符串中的密钥引用, 返回解析后的字符串和使用到的引用
+func (m *DBFactory) resolveSecretString(s string) (string, []string, error) {
+    matches := secretRefRegexp.FindAllStringSubmatchIndex(s, -1)
+    if len(matches) == 0 {
+        return s, nil, nil
+    }
+
+    var buf strings.Builder
+    var refs []string
+    last := 0
+    for _, loc := range matches {
+        name, ref := s[loc[2]:loc[3]], s[loc[4]:loc[5]]
+        provider, ok := m.getSecretProvider(name)
+        if !ok {
+            return "", nil, zerrors.NewSimplef("未注册的密钥提供者<%s>", name)
+        }
+        secret, err := provider.GetSecret(ref)
+        if err != nil {
+            return "", nil, zerrors.WrapSimplef(err, "密钥引用<%s>解析失败", s[loc[0]:loc[1]])
+        }
+
+        buf.WriteString(s[last:loc[0]])
+        buf.WriteString(secret)
+        last = loc[1]
+        refs = append(refs, name+":"+ref)
+    }
+    buf.WriteString(s[last:])
+    return buf.String(), refs, nil
+}
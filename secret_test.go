@@ -0,0 +1,80 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/22
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestBuildDBConfigRedactsSecrets(t *testing.T) {
+    secrets := &mapSecretProvider{secrets: map[string]string{
+        "timeout": "soon-secret",
+        "db":      "12x-secret",
+        "url":     "redis://:pw-secret@host:6379/abc-secret",
+        "address": "h1-secret",
+    }}
+    m := New(WithSecretProvider("test", secrets))
+
+    tests := []struct {
+        name     string
+        shard    map[string]interface{}
+        wantMsg  string // 错误信息中应包含的内容
+        wantLeak string // 错误信息中不应包含的内容, 为空时不检查
+    }{
+        {
+            name:     "duration from a secret",
+            shard:    map[string]interface{}{"dbtype": "redis", "ReadTimeout": "${test:timeout}"},
+            wantMsg:  RedactedValue,
+            wantLeak: "soon-secret",
+        },
+        {
+            name:     "int from a secret",
+            shard:    map[string]interface{}{"dbtype": "redis", "DB": "${test:db}"},
+            wantMsg:  RedactedValue,
+            wantLeak: "12x-secret",
+        },
+        {
+            name:     "secret embedded in a value",
+            shard:    map[string]interface{}{"dbtype": "redis", "DB": "1${test:db}"},
+            wantMsg:  RedactedValue,
+            wantLeak: "12x-secret",
+        },
+        {
+            name:     "url from a secret",
+            shard:    map[string]interface{}{"url": "${test:url}"},
+            wantMsg:  "它来自密钥",
+            wantLeak: "secret",
+        },
+        {
+            name:     "other field fails next to a secret",
+            shard:    map[string]interface{}{"dbtype": "redis", "Address": "${test:address}", "ReadTimeout": "later"},
+            wantMsg:  "later",
+            wantLeak: "h1-secret",
+        },
+        {
+            name:    "value without secrets is shown",
+            shard:   map[string]interface{}{"dbtype": "redis", "ReadTimeout": "later"},
+            wantMsg: "later",
+        },
+    }
+    for _, tt := range tests {
+        _, err := m.buildDBConfig("db", tt.shard)
+        if err == nil {
+            t.Errorf("%s: err = nil", tt.name)
+            continue
+        }
+        if !strings.Contains(err.Error(), tt.wantMsg) {
+            t.Errorf("%s: err = %q, want it to contain %q", tt.name, err, tt.wantMsg)
+        }
+        if tt.wantLeak != "" && strings.Contains(err.Error(), tt.wantLeak) {
+            t.Errorf("%s: err = %q leaks %q", tt.name, err, tt.wantLeak)
+        }
+    }
+}
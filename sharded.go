@@ -0,0 +1,179 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/19
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "hash/crc32"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/zlyuancn/zerrors"
+)
+
+// 分片组, 用一致性哈希将key映射到某个成员, 通过GetShard获取实例
+const Sharded DBType = "sharded"
+
+// 每个成员在哈希环上的默认虚拟节点数
+const DefaultShardedVirtualNodes = 100
+
+// 分片组配置
+type ShardedConfig struct {
+    Members      []string // 成员dbname, 成员必须是同一种db类型
+    VirtualNodes int      // 每个成员在哈希环上的虚拟节点数, 默认为100
+}
+
+func (c ShardedConfig) members() []string {
+    return lowerNames(c.Members)
+}
+
+type shardedFactory int
+
+var _ IDBFactory = (*shardedFactory)(nil)
+var _ iVirtualDBFactory = (*shardedFactory)(nil)
+
+func (shardedFactory) MakeEmptyConfig() interface{} {
+    return new(ShardedConfig)
+}
+
+func (shardedFactory) Connect(config interface{}) (interface{}, error) {
+    return nil, zerrors.NewSimple("sharded只能通过DBFactory连接")
+}
+
+func (shardedFactory) connectVirtual(m *DBFactory, info *connectInfo) (interface{}, error) {
+    var conf *ShardedConfig
+    switch c := info.config.(type) {
+    case *ShardedConfig:
+        conf = c
+    case ShardedConfig:
+        conf = &c
+    default:
+        return nil, zerrors.NewSimple("非*ShardedConfig结构")
+    }
+
+    members := conf.members()
+    if len(members) == 0 {
+        return nil, zerrors.NewSimple("sharded的members为空")
+    }
+    if _, err := m.checkMembers(members); err != nil {
+        return nil, err
+    }
+
+    g := &shardedGroup{members: members, vnodes: conf.VirtualNodes}
+    if g.vnodes <= 0 {
+        g.vnodes = DefaultShardedVirtualNodes
+    }
+    return g, nil
+}
+
+func (shardedFactory) Close(dbinstance interface{}) error {
+    if _, ok := dbinstance.(*shardedGroup); !ok {
+        return zerrors.NewSimple("非*shardedGroup结构")
+    }
+    return nil
+}
+
+// 分片组实例
+//
+// 哈希环只包含配置仍然存在的成员, 通过AddDBConfig或RemoveDB增减成员的配置后, 哈希环会在下一次GetShard时重建
+type shardedGroup struct {
+    members []string
+    vnodes  int
+
+    present []string // 构建当前哈希环时存在的成员
+    hashes  []uint32 // 排序后的虚拟节点哈希
+    nodes   map[uint32]string
+    mx      sync.Mutex
+}
+
+// 获取key对应的成员, 没有成员时返回空字符串. present为配置存在的成员
+func (g *shardedGroup) locate(key string, present []string) string {
+    g.mx.Lock()
+    defer g.mx.Unlock()
+
+    if !equalStrings(present, g.present) {
+        g.build(present)
+    }
+    if len(g.hashes) == 0 {
+        return ""
+    }
+
+    h := crc32.ChecksumIEEE([]byte(key))
+    i := sort.Search(len(g.hashes), func(i int) bool { return g.hashes[i] >= h })
+    if i == len(g.hashes) {
+        i = 0
+    }
+    return g.nodes[g.hashes[i]]
+}
+
+// 重建哈希环, 调用者需要持有锁
+func (g *shardedGroup) build(present []string) {
+    g.present = present
+    g.hashes = make([]uint32, 0, len(present)*g.vnodes)
+    g.nodes = make(map[uint32]string, len(present)*g.vnodes)
+    for _, member := range present {
+        for i := 0; i < g.vnodes; i++ {
+            h := crc32.ChecksumIEEE([]byte(member + "#" + strconv.Itoa(i)))
+            if _, ok := g.nodes[h]; ok {
+                continue
+            }
+            g.nodes[h] = member
+            g.hashes = append(g.hashes, h)
+        }
+    }
+    sort.Slice(g.hashes, func(i, j int) bool { return g.hashes[i] < g.hashes[j] })
+}
+
+// 获取分片组中key对应的成员实例
+func (m *DBFactory) GetShard(group, key string) (*DBInstance, error) {
+    group = strings.ToLower(group)
+
+    m.mx.RLock()
+    instance, ok := m.storage[group]
+    if !ok {
+        m.mx.RUnlock()
+        return nil, m.instanceNotFoundError(group)
+    }
+    g, ok := instance.instance.(*shardedGroup)
+    if !ok {
+        m.mx.RUnlock()
+        return nil, zerrors.NewSimplef("<%s>是<%v>类型, 不是%s", group, instance.dbtype, Sharded)
+    }
+
+    present := make([]string, 0, len(g.members))
+    for _, member := range g.members {
+        if _, ok := m.confs[member]; ok {
+            present = append(present, member)
+        }
+    }
+    member := g.locate(key, present)
+    out := m.storage[member]
+    m.mx.RUnlock()
+
+    if member == "" {
+        return nil, zerrors.NewSimplef("<%s>没有可用的成员", group)
+    }
+    if out == nil {
+        return nil, m.instanceNotFoundError(member)
+    }
+    return out, nil
+}
+
+func equalStrings(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
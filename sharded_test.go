@@ -0,0 +1,108 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/6/19
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "strconv"
+    "testing"
+)
+
+func TestShardedGroupLocate(t *testing.T) {
+    keys := make([]string, 3000)
+    for i := range keys {
+        keys[i] = "user:" + strconv.Itoa(i)
+    }
+
+    tests := []struct {
+        name    string
+        vnodes  int
+        present []string
+    }{
+        {name: "one member", vnodes: DefaultShardedVirtualNodes, present: []string{"s0"}},
+        {name: "three members", vnodes: DefaultShardedVirtualNodes, present: []string{"s0", "s1", "s2"}},
+        {name: "five members", vnodes: DefaultShardedVirtualNodes, present: []string{"s0", "s1", "s2", "s3", "s4"}},
+    }
+    for _, tt := range tests {
+        g := &shardedGroup{members: tt.present, vnodes: tt.vnodes}
+        other := &shardedGroup{members: tt.present, vnodes: tt.vnodes}
+
+        counts := make(map[string]int)
+        for _, key := range keys {
+            member := g.locate(key, tt.present)
+            if !containsString(tt.present, member) {
+                t.Fatalf("%s: locate(%q) = %q, not a present member", tt.name, key, member)
+            }
+            if again := other.locate(key, tt.present); again != member {
+                t.Fatalf("%s: locate(%q) = %q on another ring, want %q", tt.name, key, again, member)
+            }
+            counts[member]++
+        }
+
+        // 每个成员至少分到平均值的一半
+        min := len(keys) / len(tt.present) / 2
+        for _, member := range tt.present {
+            if counts[member] < min {
+                t.Errorf("%s: member %q got %d keys, want at least %d", tt.name, member, counts[member], min)
+            }
+        }
+    }
+}
+
+func TestShardedGroupRebuild(t *testing.T) {
+    keys := make([]string, 1000)
+    for i := range keys {
+        keys[i] = "order:" + strconv.Itoa(i)
+    }
+    members := []string{"s0", "s1", "s2", "s3"}
+
+    tests := []struct {
+        name   string
+        before []string
+        after  []string
+    }{
+        {name: "remove a member", before: members, after: []string{"s0", "s1", "s3"}},
+        {name: "add a member", before: []string{"s0", "s1", "s3"}, after: members},
+        {name: "remove two members", before: members, after: []string{"s1", "s3"}},
+    }
+    for _, tt := range tests {
+        g := &shardedGroup{members: members, vnodes: DefaultShardedVirtualNodes}
+
+        before := make(map[string]string, len(keys))
+        for _, key := range keys {
+            before[key] = g.locate(key, tt.before)
+        }
+        for _, key := range keys {
+            old, now := before[key], g.locate(key, tt.after)
+            if !containsString(tt.after, now) {
+                t.Fatalf("%s: locate(%q) = %q, not a present member", tt.name, key, now)
+            }
+            // 只有被移除的成员或新增的成员所涉及的key会移动
+            if old != now && containsString(tt.after, old) && containsString(tt.before, now) {
+                t.Errorf("%s: key %q moved from %q to %q", tt.name, key, old, now)
+            }
+        }
+    }
+}
+
+func TestShardedGroupEmpty(t *testing.T) {
+    tests := []struct {
+        name    string
+        present []string
+    }{
+        {name: "nil", present: nil},
+        {name: "empty", present: []string{}},
+    }
+    for _, tt := range tests {
+        g := &shardedGroup{members: []string{"s0"}, vnodes: DefaultShardedVirtualNodes}
+        g.locate("key", []string{"s0"})
+        if got := g.locate("key", tt.present); got != "" {
+            t.Errorf("%s: locate = %q, want empty", tt.name, got)
+        }
+    }
+}
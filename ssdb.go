@@ -9,6 +9,8 @@
 package zdbfactory
 
 import (
+    "context"
+
     "github.com/seefan/gossdb"
     ssdbconf "github.com/seefan/gossdb/conf"
     "github.com/zlyuancn/zerrors"
@@ -17,15 +19,25 @@ import (
 type ssdbFactory int
 
 var _ IDBFactory = (*ssdbFactory)(nil)
+var _ IDBPinger = (*ssdbFactory)(nil)
 
 type SsdbConfig struct {
     Host             string
     Port             int
     Password         string
-    GetClientTimeout int  // 获取客户端超时(毫秒)
-    MinPoolSize      int  // 最小连接池数
-    MaxPoolSize      int  // 最大连接池个数
-    RetryEnabled     bool // 是否启用重试，设置为true时，如果请求失败会再重试一次
+    GetClientTimeout Duration // 获取客户端超时(毫秒), ssdb以秒为单位, 不足1秒按1秒处理
+    ConnectTimeout   Duration // 创建连接超时(毫秒), ssdb以秒为单位, 不足1秒按1秒处理
+    ReadWriteTimeout Duration // 连接读写超时(毫秒), ssdb以秒为单位, 不足1秒按1秒处理
+    WriteBufferSize  int      // 连接写缓冲(kb), 默认为8
+    ReadBufferSize   int      // 连接读缓冲(kb), 默认为8
+    MinPoolSize      int      // 最小连接池数
+    MaxPoolSize      int      // 最大连接池个数
+    AcquireIncrement int      // 当连接池中的连接耗尽的时候一次同时获取的连接数
+    MaxWaitSize      int      // 连接池满后最大等待数目, 超过后获取连接会失败
+    HealthSecond     int      // 连接池内连接的状态检查间隔(秒)
+    IdleTime         int      // 连接空闲时间(秒), 超过这个时间可能会被回收
+    RetryEnabled     bool     // 是否启用重试，设置为true时，如果请求失败会再重试一次
+    Ping             bool     // 开始连接时是否ping确认连接情况
 }
 
 func (ssdbFactory) MakeEmptyConfig() interface{} {
@@ -47,17 +59,56 @@ func (ssdbFactory) Connect(config interface{}) (interface{}, error) {
         Host:             conf.Host,
         Port:             conf.Port,
         Password:         conf.Password,
-        GetClientTimeout: conf.GetClientTimeout / 1e3,
+        GetClientTimeout: conf.GetClientTimeout.Seconds(),
+        ConnectTimeout:   conf.ConnectTimeout.Seconds(),
+        ReadWriteTimeout: conf.ReadWriteTimeout.Seconds(),
+        WriteBufferSize:  conf.WriteBufferSize,
+        ReadBufferSize:   conf.ReadBufferSize,
         MinPoolSize:      conf.MinPoolSize,
         MaxPoolSize:      conf.MaxPoolSize,
+        AcquireIncrement: conf.AcquireIncrement,
+        MaxWaitSize:      conf.MaxWaitSize,
+        HealthSecond:     conf.HealthSecond,
+        IdleTime:         conf.IdleTime,
         RetryEnabled:     conf.RetryEnabled,
     })
     if err != nil {
         return nil, zerrors.WrapSimple(err, "连接失败")
     }
 
+    if conf.Ping {
+        if err = ssdbPing(pool); err != nil {
+            pool.Close()
+            return nil, zerrors.WrapSimple(err, "ping失败")
+        }
+    }
+
     return pool, nil
 }
+
+// ping一次ssdb
+func ssdbPing(pool *gossdb.Connectors) error {
+    c, err := pool.NewClient()
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+
+    _, err = c.Info()
+    return err
+}
+
+func (ssdbFactory) Ping(ctx context.Context, dbinstance interface{}) error {
+    c, ok := dbinstance.(*gossdb.Connectors)
+    if !ok {
+        return zerrors.NewSimple("非*gossdb.Connectors结构")
+    }
+
+    return pingWithContext(ctx, func() error {
+        return ssdbPing(c)
+    })
+}
+
 func (ssdbFactory) Close(dbinstance interface{}) error {
     c, ok := dbinstance.(*gossdb.Connectors)
     if !ok {
@@ -77,7 +128,7 @@ func AddSsdbConfig(dbname string, conf *SsdbConfig) {
 func GetSsdb(dbname string) (*gossdb.Connectors, error) {
     a := defaultDBFactory.GetDBInstance(dbname)
     if a == nil {
-        return nil, zerrors.NewSimplef("不存在的dbname<%s>", dbname)
+        return nil, defaultDBFactory.instanceNotFoundError(dbname)
     }
     if a.Type() != SSDB {
         return nil, zerrors.NewSimplef("db实例<%s>是<%v>类型", dbname, a.dbtype)
@@ -0,0 +1,286 @@
+/*
+-------------------------------------------------
+   Author :       Zhang Fan
+   date：         2020/5/16
+   Description :
+-------------------------------------------------
+*/
+
+package zdbfactory
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+
+    "github.com/Shopify/sarama"
+    "github.com/go-redis/redis"
+    "github.com/jinzhu/gorm"
+    "go.mongodb.org/mongo-driver/event"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// tracer的名字
+const TracerName = "github.com/zlyuancn/zdbfactory"
+
+// span的属性
+const (
+    TraceDBSystemKey  = attribute.Key("db.system")
+    TraceDBNameKey    = attribute.Key("zdb.dbname")
+    TraceStatementKey = attribute.Key("db.statement")
+)
+
+// 各db类型对应的db.system
+var traceDBSystem = map[DBType]string{
+    Mongo:         "mongodb",
+    Redis:         "redis",
+    ESv6:          "elasticsearch",
+    ESv7:          "elasticsearch",
+    Mysql:         "mysql",
+    SSDB:          "ssdb",
+    ETCD:          "etcd",
+    KafkaProducer: "kafka",
+}
+
+// 链路追踪插件
+type tracingPlugin struct {
+    tracer trace.Tracer
+}
+
+var (
+    _ redisPlugin = (*tracingPlugin)(nil)
+    _ gormPlugin  = (*tracingPlugin)(nil)
+    _ httpPlugin  = (*tracingPlugin)(nil)
+    _ kafkaPlugin = (*tracingPlugin)(nil)
+    _ mongoPlugin = (*tracingPlugin)(nil)
+)
+
+func newTracingPlugin(tp trace.TracerProvider) *tracingPlugin {
+    return &tracingPlugin{tracer: tp.Tracer(TracerName)}
+}
+
+// 开始一个span
+func (m *tracingPlugin) start(ctx context.Context, info *connectInfo, operation, statement string) trace.Span {
+    system, ok := traceDBSystem[info.dbtype]
+    if !ok {
+        system = string(info.dbtype)
+    }
+
+    _, span := m.tracer.Start(ctx, system+"."+operation,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(
+            TraceDBSystemKey.String(system),
+            TraceDBNameKey.String(info.dbname),
+            TraceStatementKey.String(statement),
+        ),
+    )
+    return span
+}
+
+// 结束一个span
+func endSpan(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
+
+// go-redis v6的命令不携带context, 所以redis的span没有父span
+func (m *tracingPlugin) wrapRedis(info *connectInfo, c redis.UniversalClient) {
+    c.WrapProcess(func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+        return func(cmd redis.Cmder) error {
+            span := m.start(context.Background(), info, cmd.Name(), redisStatement(cmd))
+            err := oldProcess(cmd)
+            if err == redis.Nil {
+                endSpan(span, nil)
+            } else {
+                endSpan(span, err)
+            }
+            return err
+        }
+    })
+    c.WrapProcessPipeline(func(oldProcess func([]redis.Cmder) error) func([]redis.Cmder) error {
+        return func(cmds []redis.Cmder) error {
+            statements := make([]string, len(cmds))
+            for i, cmd := range cmds {
+                statements[i] = redisStatement(cmd)
+            }
+            span := m.start(context.Background(), info, "pipeline", strings.Join(statements, "\n"))
+            err := oldProcess(cmds)
+            if err == redis.Nil {
+                endSpan(span, nil)
+            } else {
+                endSpan(span, err)
+            }
+            return err
+        }
+    })
+}
+
+// redis命令的语句, 只保留命令名和key, 避免将值写入span
+func redisStatement(cmd redis.Cmder) string {
+    args := cmd.Args()
+    if len(args) > 2 {
+        args = args[:2]
+    }
+    parts := make([]string, len(args))
+    for i, a := range args {
+        parts[i] = fmt.Sprint(a)
+    }
+    return strings.Join(parts, " ")
+}
+
+const gormSpanKey = "zdb:trace_span"
+
+func (m *tracingPlugin) registerGorm(info *connectInfo, db *gorm.DB) {
+    before := func(operation string) func(scope *gorm.Scope) {
+        return func(scope *gorm.Scope) {
+            scope.InstanceSet(gormSpanKey, m.start(gormContext(scope), info, operation, ""))
+        }
+    }
+    after := func(scope *gorm.Scope) {
+        v, ok := scope.InstanceGet(gormSpanKey)
+        if !ok {
+            return
+        }
+        span := v.(trace.Span)
+        span.SetAttributes(TraceStatementKey.String(scope.SQL))
+        if scope.HasError() && !gorm.IsRecordNotFoundError(scope.DB().Error) {
+            endSpan(span, scope.DB().Error)
+        } else {
+            endSpan(span, nil)
+        }
+    }
+
+    cb := db.Callback()
+    cb.Create().Before("gorm:create").Register("zdb:trace_before_create", before("create"))
+    cb.Create().After("gorm:create").Register("zdb:trace_after_create", after)
+    cb.Update().Before("gorm:update").Register("zdb:trace_before_update", before("update"))
+    cb.Update().After("gorm:update").Register("zdb:trace_after_update", after)
+    cb.Delete().Before("gorm:delete").Register("zdb:trace_before_delete", before("delete"))
+    cb.Delete().After("gorm:delete").Register("zdb:trace_after_delete", after)
+    cb.Query().Before("gorm:query").Register("zdb:trace_before_query", before("query"))
+    cb.Query().After("gorm:query").Register("zdb:trace_after_query", after)
+    cb.RowQuery().Before("gorm:row_query").Register("zdb:trace_before_row_query", before("row_query"))
+    cb.RowQuery().After("gorm:row_query").Register("zdb:trace_after_row_query", after)
+}
+
+func (m *tracingPlugin) wrapTransport(info *connectInfo, rt http.RoundTripper) http.RoundTripper {
+    return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+        span := m.start(req.Context(), info, req.Method, req.Method+" "+req.URL.Path)
+        resp, err := rt.RoundTrip(req)
+        if err == nil && resp.StatusCode >= 500 {
+            span.SetStatus(codes.Error, resp.Status)
+        }
+        endSpan(span, err)
+        return resp, err
+    })
+}
+
+func (m *tracingPlugin) wrapSyncProducer(info *connectInfo, p sarama.SyncProducer) sarama.SyncProducer {
+    return &tracingSyncProducer{SyncProducer: p, plugin: m, info: info}
+}
+
+// 异步生产者的结果在Successes和Errors中返回, 写入Input时无法得知发送耗时和结果, 所以不进行追踪
+func (m *tracingPlugin) wrapAsyncProducer(info *connectInfo, p sarama.AsyncProducer) sarama.AsyncProducer {
+    return p
+}
+
+// 带链路追踪的同步生产者
+type tracingSyncProducer struct {
+    sarama.SyncProducer
+    plugin *tracingPlugin
+    info   *connectInfo
+}
+
+func (m *tracingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+    span := m.plugin.start(kafkaMessageContext(msg), m.info, "send", msg.Topic)
+    partition, offset, err = m.SyncProducer.SendMessage(msg)
+    endSpan(span, err)
+    return
+}
+
+func (m *tracingSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+    ctx := context.Background()
+    topics := make([]string, len(msgs))
+    for i, msg := range msgs {
+        topics[i] = msg.Topic
+    }
+    if len(msgs) > 0 {
+        ctx = kafkaMessageContext(msgs[0])
+    }
+    span := m.plugin.start(ctx, m.info, "send_batch", strings.Join(topics, ","))
+    err := m.SyncProducer.SendMessages(msgs)
+    endSpan(span, err)
+    return err
+}
+
+// 从消息头中提取调用者的链路, 调用者需要用otel的全局propagator将链路注入到消息头中
+//
+// 不会向消息头写入链路, 因为kafka 0.11以下的版本不支持消息头
+func kafkaMessageContext(msg *sarama.ProducerMessage) context.Context {
+    return otel.GetTextMapPropagator().Extract(context.Background(), kafkaHeaderCarrier{msg})
+}
+
+// 从kafka消息头中读取链路的carrier
+type kafkaHeaderCarrier struct {
+    msg *sarama.ProducerMessage
+}
+
+func (c kafkaHeaderCarrier) Get(key string) string {
+    for _, h := range c.msg.Headers {
+        if string(h.Key) == key {
+            return string(h.Value)
+        }
+    }
+    return ""
+}
+
+func (c kafkaHeaderCarrier) Set(key, value string) {}
+
+func (c kafkaHeaderCarrier) Keys() []string {
+    keys := make([]string, len(c.msg.Headers))
+    for i, h := range c.msg.Headers {
+        keys[i] = string(h.Key)
+    }
+    return keys
+}
+
+func (m *tracingPlugin) commandMonitor(info *connectInfo) *event.CommandMonitor {
+    var spans sync.Map
+    finish := func(requestID int64, err error) {
+        if v, ok := spans.Load(requestID); ok {
+            spans.Delete(requestID)
+            endSpan(v.(trace.Span), err)
+        }
+    }
+    return &event.CommandMonitor{
+        Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+            spans.Store(e.RequestID, m.start(ctx, info, e.CommandName, mongoStatement(e)))
+        },
+        Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+            finish(e.RequestID, nil)
+        },
+        Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+            finish(e.RequestID, stringError(e.Failure))
+        },
+    }
+}
+
+// mongo命令的语句, 只保留命令名和集合名, 避免将文档和过滤条件写入span
+func mongoStatement(e *event.CommandStartedEvent) string {
+    if coll, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+        return e.CommandName + " " + coll
+    }
+    return e.CommandName
+}
+
+type stringError string
+
+func (e stringError) Error() string { return string(e) }